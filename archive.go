@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// ArchiveEntry records a video downloaded in one of the previous runs,
+// together with the metadata confirmed in the editor.
+type ArchiveEntry struct {
+	ID         string    `json:"id"`
+	Path       string    `json:"path"`
+	Downloaded time.Time `json:"downloaded"`
+	// Removed is set once sync moves the file out of a playlist folder.
+	Removed bool `json:"removed,omitempty"`
+	// Playlist is the ID of the playlist the video was downloaded from, empty
+	// for links read from a file.
+	Playlist string `json:"playlist,omitempty"`
+
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album,omitempty"`
+	AlbumArtist string `json:"album_artist,omitempty"`
+	Year        int    `json:"year,omitempty"`
+	Genre       string `json:"genre,omitempty"`
+	TrackNumber int    `json:"track,omitempty"`
+}
+
+// apply restores the confirmed metadata on a song.
+func (e ArchiveEntry) apply(s *Song) {
+	s.Title = e.Title
+	s.Artist = e.Artist
+	s.Album = e.Album
+	s.AlbumArtist = e.AlbumArtist
+	s.Year = e.Year
+	s.Genre = e.Genre
+	s.TrackNumber = e.TrackNumber
+}
+
+// Archive is a file listing downloaded videos, so later runs can skip them.
+type Archive struct {
+	path    string
+	entries map[string]ArchiveEntry
+}
+
+// LoadArchive reads the archive at path. A missing file gives an empty archive.
+func LoadArchive(path string) (*Archive, error) {
+	archive := Archive{path: path, entries: make(map[string]ArchiveEntry)}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &archive, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Could not read archive file: %v", err)
+	}
+
+	entries := make([]ArchiveEntry, 0)
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("Could not parse archive file %s: %v", path, err)
+	}
+
+	for _, entry := range entries {
+		archive.entries[entry.ID] = entry
+	}
+
+	return &archive, nil
+}
+
+// Contains reports whether the video behind link was already downloaded.
+func (a *Archive) Contains(link string) bool {
+	_, ok := a.Entry(link)
+	return ok
+}
+
+// Entry returns the archive entry of the video behind link.
+func (a *Archive) Entry(link string) (ArchiveEntry, bool) {
+	id, err := youtube.ExtractVideoID(link)
+	if err != nil {
+		return ArchiveEntry{}, false
+	}
+
+	entry, ok := a.entries[id]
+	return entry, ok
+}
+
+// Filter returns links that are not in the archive and the number of links
+// that were left out.
+func (a *Archive) Filter(links []string) ([]string, int) {
+	r := make([]string, 0, len(links))
+	for _, link := range links {
+		if !a.Contains(link) {
+			r = append(r, link)
+		}
+	}
+
+	return r, len(links) - len(r)
+}
+
+// Add records a song downloaded from the given playlist and writes the
+// archive to disk.
+func (a *Archive) Add(s *Song, path string, playlist string) error {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	a.entries[s.Video.ID] = ArchiveEntry{
+		ID:          s.Video.ID,
+		Path:        path,
+		Downloaded:  time.Now(),
+		Playlist:    playlist,
+		Title:       s.Title,
+		Artist:      s.Artist,
+		Album:       s.Album,
+		AlbumArtist: s.AlbumArtist,
+		Year:        s.Year,
+		Genre:       s.Genre,
+		TrackNumber: s.TrackNumber,
+	}
+
+	return a.save()
+}
+
+func (a *Archive) save() error {
+	entries := make([]ArchiveEntry, 0, len(a.entries))
+	for _, entry := range a.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Downloaded.Before(entries[j].Downloaded)
+	})
+
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = writeFileAtomic(a.path, b); err != nil {
+		return fmt.Errorf("Could not write archive file: %v", err)
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes data next to path and renames it into place, so a
+// crash never leaves the file half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.json")
+
+	archive, err := LoadArchive(path)
+	require.NoError(t, err)
+	song := Song{Title: "Kings & Queens", Artist: "Ava Max", Video: &youtube.Video{ID: "jH1RNk8954Q"}}
+	require.NoError(t, archive.Add(&song, "Ava Max - Kings & Queens.mp3", ""))
+
+	archive, err = LoadArchive(path)
+	require.NoError(t, err)
+	require.Equal(t, "Ava Max - Kings & Queens.mp3", filepath.Base(archive.entries["jH1RNk8954Q"].Path))
+	require.Equal(t, "Kings & Queens", archive.entries["jH1RNk8954Q"].Title)
+
+	links, skipped := archive.Filter([]string{
+		"https://www.youtube.com/watch?v=jH1RNk8954Q",
+		"https://www.youtube.com/watch?v=McJcDToEiyw",
+	})
+	require.Equal(t, []string{"https://www.youtube.com/watch?v=McJcDToEiyw"}, links)
+	require.Equal(t, 1, skipped)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "session.json")
+
+	require.NoError(t, writeFileAtomic(path, []byte("old")))
+	require.NoError(t, writeFileAtomic(path, []byte("new")))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(b))
+
+	// No temporary file is left behind.
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	require.Error(t, writeFileAtomic(filepath.Join(dir, "missing", "session.json"), nil))
+}
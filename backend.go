@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Backend provides the encoders and taggers that turn downloaded streams
+// into output formats.
+type Backend interface {
+	Name() string
+	// OutputFormat returns the output format registered under the given name.
+	OutputFormat(name string) (OutputFormat, error)
+}
+
+// ffmpegBackend converts with ffmpeg, which supports every output format.
+type ffmpegBackend struct{}
+
+func (ffmpegBackend) Name() string {
+	return "ffmpeg"
+}
+
+func (ffmpegBackend) OutputFormat(name string) (OutputFormat, error) {
+	return lookupFormat(outputFormats, name)
+}
+
+// goBackend only copies AAC streams of mp4 sources into m4a and aac files,
+// which needs no external tools.
+type goBackend struct{}
+
+var goFormats = map[string]OutputFormat{
+	"m4a": {
+		Extension: "m4a",
+		Sources:   []string{mimeAAC},
+		Encoder:   mp4Remuxer{},
+		Tagger:    mp4Tagger{},
+	},
+	"aac": {
+		Extension: "aac",
+		Sources:   []string{mimeAAC},
+		Encoder:   adtsExtractor{},
+		Tagger:    id3Tagger{},
+	},
+}
+
+func (goBackend) Name() string {
+	return "go"
+}
+
+func (goBackend) OutputFormat(name string) (OutputFormat, error) {
+	if _, ok := goFormats[strings.ToLower(name)]; !ok {
+		if _, ok := outputFormats[strings.ToLower(name)]; ok {
+			return OutputFormat{}, fmt.Errorf("Format %s needs ffmpeg, without it only m4a and aac are supported.", name)
+		}
+	}
+
+	return lookupFormat(goFormats, name)
+}
+
+// SelectBackend returns the backend with the given name. Auto uses ffmpeg
+// when it is found on PATH and the built-in backend otherwise.
+func SelectBackend(name string) (Backend, error) {
+	switch strings.ToLower(name) {
+	case "auto":
+		if ffmpegAvailable() {
+			return ffmpegBackend{}, nil
+		}
+		return goBackend{}, nil
+	case "ffmpeg":
+		if !ffmpegAvailable() {
+			return nil, fmt.Errorf("Could not find ffmpeg on PATH.")
+		}
+		return ffmpegBackend{}, nil
+	case "go":
+		return goBackend{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown backend \"%s\", expected auto, ffmpeg or go.", name)
+	}
+}
+
+func ffmpegAvailable() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoBackend(t *testing.T) {
+	backend, err := SelectBackend("go")
+	require.NoError(t, err)
+
+	for _, name := range []string{"m4a", "aac"} {
+		format, err := backend.OutputFormat(name)
+		require.NoError(t, err, name)
+		require.Equal(t, name, format.Extension)
+	}
+
+	_, err = backend.OutputFormat("mp3")
+	require.ErrorContains(t, err, "ffmpeg")
+
+	// Sources that are not AAC in mp4 can not be converted without ffmpeg.
+	format, _ := backend.OutputFormat("m4a")
+	err = format.Encoder.Encode(context.Background(), "in", "out", &youtube.Format{MimeType: mimeOpus}, defaultQuality)
+	require.ErrorContains(t, err, "ffmpeg")
+
+	_, err = SelectBackend("sox")
+	require.Error(t, err)
+}
+
+func TestRetryCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+	err := retry(ctx, 5, time.Hour, func() error {
+		attempts += 1
+		cancel()
+		return errors.New("Could not read video stream.")
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestDownloadGroup(t *testing.T) {
+	defer func(g *downloadGroup) { downloads = g }(downloads)
+	downloads = newDownloadGroup()
+
+	require.True(t, downloads.start())
+	// Queued before quitting, but not started yet.
+	queued := downloadCmd(context.Background(), &Song{}, 1)
+
+	waited := make(chan struct{})
+	go func() {
+		downloads.wait()
+		close(waited)
+	}()
+
+	// The running download is waited for.
+	select {
+	case <-waited:
+		t.Fatal("wait returned while a download was running")
+	case <-time.After(10 * time.Millisecond):
+	}
+	downloads.done()
+	<-waited
+
+	// The queued one never starts, its song is not touched.
+	msg, ok := queued().(downloadErrorMsg)
+	require.True(t, ok)
+	require.Equal(t, 1, msg.index)
+	require.ErrorIs(t, msg.result.err, context.Canceled)
+	require.False(t, downloads.start())
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// AcceptPolicy decides which parsed songs batch mode downloads without review.
+type AcceptPolicy int
+
+const (
+	// Accept only songs parsed with Reliable == Yes.
+	AcceptReliable AcceptPolicy = iota
+	// Accept songs parsed with Reliable == Yes or Maybe.
+	AcceptMaybe
+	// Accept every song.
+	AcceptAll
+)
+
+var acceptPolicies = map[string]AcceptPolicy{
+	"reliable": AcceptReliable,
+	"maybe":    AcceptMaybe,
+	"all":      AcceptAll,
+}
+
+func ParseAcceptPolicy(name string) (AcceptPolicy, error) {
+	policy, ok := acceptPolicies[strings.ToLower(name)]
+	if !ok {
+		return AcceptReliable, fmt.Errorf("Unknown accept policy \"%s\", expected reliable, maybe or all.", name)
+	}
+
+	return policy, nil
+}
+
+func (p AcceptPolicy) accepts(s *Song) bool {
+	switch p {
+	case AcceptAll:
+		return true
+	case AcceptMaybe:
+		return s.Reliable <= Maybe
+	default:
+		return s.Reliable == Yes
+	}
+}
+
+// reviewFile lists links batch mode did not accept. It can be used as source
+// of an interactive run.
+const reviewFile = "review.txt"
+
+// runBatch downloads songs without the editor, using parsed metadata and
+// printing plain text progress.
+func runBatch(ctx context.Context, links []string, album Album, archive *Archive, policy AcceptPolicy) {
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	songs, errs := fetchAll(ctx, logger, links)
+
+	report := make([]ReportEntry, 0, len(links))
+	acceptedLinks := make([]string, 0, len(links))
+	accepted := make([]*Song, 0, len(links))
+	review := make([]string, 0)
+
+	for i, song := range songs {
+		if song == nil {
+			report = append(report, fetchReport(links[i], errs[i]))
+			continue
+		}
+
+		song.Album = album.Title
+		song.TrackNumber = album.Tracks[links[i]]
+
+		// Songs confirmed in a previous run need no review.
+		entry, confirmed := archive.Entry(links[i])
+		if confirmed {
+			entry.apply(song)
+		}
+
+		if confirmed || policy.accepts(song) {
+			acceptedLinks = append(acceptedLinks, links[i])
+			accepted = append(accepted, song)
+		} else {
+			review = append(review, links[i])
+			report = append(report, songReport(links[i], song, Skipped, downloadResult{}))
+			logger.Printf("Review: %s - %s (%s)", song.Artist, song.Title, links[i])
+		}
+	}
+
+	report = append(report, downloadAll(ctx, logger, acceptedLinks, accepted, archive)...)
+	failed := failedLinks(report)
+
+	if err := writeLinks("failed.txt", failed); err != nil {
+		logger.Println(err)
+	}
+	if len(review) > 0 {
+		if err := writeLinks(reviewFile, review); err != nil {
+			logger.Println(err)
+		}
+	}
+	if err := WriteReport(report); err != nil {
+		logger.Println(err)
+	}
+
+	logger.Printf("%d downloaded • %d failed • %d to review in %s", countStatus(report, Downloaded), len(failed), len(review), filepath.Join(output, reviewFile))
+}
+
+// downloadAll downloads songs in parallel and records them in the archive.
+// Links are the ones songs were fetched from.
+func downloadAll(ctx context.Context, logger *log.Logger, links []string, songs []*Song, archive *Archive) []ReportEntry {
+	var mu sync.Mutex
+	downloadCount := 0
+	report := make([]ReportEntry, len(songs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < max(*downloadWorkers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				song := songs[i]
+				if ctx.Err() != nil {
+					report[i] = songReport(links[i], song, DownloadFailed, downloadResult{err: ctx.Err()})
+					continue
+				}
+				if source, err := FindFormat(song.Video.Formats, outputFormat.Preference, outputFormat.Sources...); err == nil {
+					if warning := outputFormat.BitrateWarning(source); warning != "" {
+						logger.Printf("Warning: %s - %s: %s", song.Artist, song.Title, warning)
+					}
+				}
+				result := download(ctx, song, nil)
+
+				mu.Lock()
+				if isConflict(result.err) {
+					report[i] = songReport(links[i], song, Skipped, result)
+					logger.Printf("Skipped %s - %s: %v", song.Artist, song.Title, result.err)
+				} else if result.err != nil {
+					report[i] = songReport(links[i], song, DownloadFailed, result)
+					logger.Printf("Failed: %s - %s: %v", song.Artist, song.Title, result.err)
+				} else {
+					report[i] = songReport(links[i], song, Downloaded, result)
+					downloadCount += 1
+					logger.Printf("[%d/%d] Downloaded %s", downloadCount, len(songs), result.path)
+					if result.warning != "" {
+						logger.Printf("Warning: %s: %s", result.path, result.warning)
+					}
+					if err := archive.Add(song, result.path, playlistID(source)); err != nil {
+						logger.Println(err)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range songs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return report
+}
+
+// fetchAll fetches metadata of every link in parallel. Songs that could not
+// be fetched are nil and have their error set.
+func fetchAll(ctx context.Context, logger *log.Logger, links []string) ([]*Song, []error) {
+	songs := make([]*Song, len(links))
+	errs := make([]error, len(links))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < max(*fetchWorkers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					errs[i] = ctx.Err()
+					continue
+				}
+				song, err := GetSong(ctx, &client, links[i])
+				if err != nil {
+					logger.Printf("Could not fetch %s: %v", links[i], err)
+					errs[i] = err
+					continue
+				}
+				songs[i] = song
+			}
+		}()
+	}
+
+	for i := range links {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed += 1
+		}
+	}
+	logger.Printf("Fetched metadata of %d videos, %d could not be fetched.", len(links)-failed, failed)
+	return songs, errs
+}
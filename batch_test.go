@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptPolicy(t *testing.T) {
+	reliable := &Song{Reliable: Yes}
+	maybe := &Song{Reliable: Maybe}
+	no := &Song{Reliable: No}
+
+	policy, err := ParseAcceptPolicy("reliable")
+	require.NoError(t, err)
+	require.True(t, policy.accepts(reliable))
+	require.False(t, policy.accepts(maybe))
+	require.False(t, policy.accepts(no))
+
+	policy, err = ParseAcceptPolicy("Maybe")
+	require.NoError(t, err)
+	require.True(t, policy.accepts(maybe))
+	require.False(t, policy.accepts(no))
+
+	policy, err = ParseAcceptPolicy("all")
+	require.NoError(t, err)
+	require.True(t, policy.accepts(no))
+
+	_, err = ParseAcceptPolicy("some")
+	require.Error(t, err)
+}
@@ -1,61 +1,171 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
-func fetchCmd(link string) tea.Cmd {
+func fetchCmd(ctx context.Context, link string, index int) tea.Cmd {
 	return func() tea.Msg {
-		song, err := GetSong(&client, link)
-		if err != nil {
-			return errorMsg(err)
-		}
+		song, err := GetSong(ctx, &client, link)
+		return fetchMsg{index: index, song: song, err: err}
+	}
+}
+
+// downloadGroup tracks running downloads, so quitting can wait for them to
+// stop and clean up their files. Unlike a WaitGroup it does not wait for cmds
+// the program drops when it quits, those are kept from starting instead.
+type downloadGroup struct {
+	mu       sync.Mutex
+	finished *sync.Cond
+	running  int
+	closed   bool
+}
+
+var downloads = newDownloadGroup()
+
+func newDownloadGroup() *downloadGroup {
+	g := &downloadGroup{}
+	g.finished = sync.NewCond(&g.mu)
+	return g
+}
 
-		return fetchMsg(song)
+// start reports whether a download may run, which it may not once the group
+// is waited for.
+func (g *downloadGroup) start() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return false
 	}
+	g.running += 1
+	return true
 }
 
-func downloadCmd(song *Song, index int) tea.Cmd {
+func (g *downloadGroup) done() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.running -= 1
+	g.finished.Broadcast()
+}
+
+// wait stops downloads that did not start yet from starting and waits for
+// running ones.
+func (g *downloadGroup) wait() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.closed = true
+	for g.running > 0 {
+		g.finished.Wait()
+	}
+}
+
+// downloadCmd downloads the song, unless the program quit before it started.
+func downloadCmd(ctx context.Context, song *Song, index int) tea.Cmd {
 	return func() tea.Msg {
+		if !downloads.start() {
+			return downloadErrorMsg{index: index, result: downloadResult{err: context.Canceled}}
+		}
+		defer downloads.done()
+		if ctx.Err() != nil {
+			return downloadErrorMsg{index: index, result: downloadResult{err: ctx.Err()}}
+		}
 
-		max, min := 5, 1
-		delay := rand.Intn(max-min) + min
-		err := retry(5, time.Duration(delay)*time.Second, func() error {
-			return song.Save(output)
+		result := download(ctx, song, func(received int64, total int64) {
+			program.Send(progressMsg{index: index, received: received, total: total})
 		})
 
-		if err != nil {
-			return errorMsg(err)
+		if isConflict(result.err) {
+			return downloadSkipMsg{index: index, result: result}
+		}
+		if result.err != nil {
+			return downloadErrorMsg{index: index, result: result}
 		}
-		return downloadMsg(index)
+		return downloadMsg{index: index, result: result}
 	}
 }
 
-func retry(attempts int, sleep time.Duration, f func() error) error {
+// download saves the song into the output folder, retrying with a growing
+// delay when it fails. Cancelling ctx stops retrying.
+func download(ctx context.Context, song *Song, progress ProgressFunc) downloadResult {
+	max, min := 5, 1
+	delay := rand.Intn(max-min) + min
+
+	var result downloadResult
+	started := time.Now()
+	attempts := 0
+	var conflict error
+	result.err = retry(ctx, 5, time.Duration(delay)*time.Second, func() (err error) {
+		attempts += 1
+		result.path, result.warning, err = song.Save(ctx, output, outputFormat, progress)
+		// Another attempt would find the same file.
+		if isConflict(err) {
+			conflict = err
+			return nil
+		}
+		return err
+	})
+	if conflict != nil {
+		result.err = conflict
+	}
+	result.retries = attempts - 1
+	result.duration = time.Since(started)
+
+	if info, err := os.Stat(result.path); result.err == nil && err == nil {
+		result.size = info.Size()
+	}
+
+	return result
+}
+
+func retry(ctx context.Context, attempts int, sleep time.Duration, f func() error) error {
 	if err := f(); err != nil {
-		if attempts--; attempts > 0 {
-			time.Sleep(sleep)
-			return retry(attempts, 4*sleep, f)
+		if attempts--; attempts > 0 && ctx.Err() == nil {
+			select {
+			case <-time.After(sleep):
+			case <-ctx.Done():
+				return err
+			}
+			return retry(ctx, attempts, 4*sleep, f)
 		}
 		return err
 	}
 	return nil
 }
 
-func saveCmd(skipped []string) tea.Cmd {
+// saveCmd writes the run report and, like batch mode, lists links that
+// could not be fetched or downloaded in failed.txt.
+func saveCmd(report []ReportEntry) tea.Cmd {
+	failed := failedLinks(report)
 	return func() tea.Msg {
-		path := output + "failed.txt"
-		b := []byte(strings.Join(skipped, "\n"))
-		err := ioutil.WriteFile(path, b, 0644)
-		if err != nil {
+		if err := writeLinks("failed.txt", failed); err != nil {
+			return errorMsg(err)
+		}
+		if err := WriteReport(report); err != nil {
 			return errorMsg(err)
 		}
 
-		return saveMsg(len(skipped))
+		// Nothing is left to resume once every song is handled.
+		os.Remove(sessionPath)
+
+		return saveMsg(len(failed))
 	}
 }
+
+// writeLinks writes links into a file in the output folder, one per line.
+func writeLinks(name string, links []string) error {
+	path := filepath.Join(output, name)
+	b := []byte(strings.Join(links, "\n"))
+	return ioutil.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// ConflictPolicy decides what happens when a song would be saved to a file
+// that already exists or was taken by another song of the session.
+type ConflictPolicy int
+
+const (
+	// Keep the existing file and skip the song.
+	ConflictSkip ConflictPolicy = iota
+	// Replace the existing file.
+	ConflictOverwrite
+	// Save the song under a free name such as "Artist - Title (2)".
+	ConflictRename
+	// Keep the longer file, or the one with the higher bitrate.
+	ConflictCompare
+)
+
+var conflictPolicies = map[string]ConflictPolicy{
+	"skip":      ConflictSkip,
+	"overwrite": ConflictOverwrite,
+	"rename":    ConflictRename,
+	"compare":   ConflictCompare,
+}
+
+// conflictNotes tell in the editor what happens to a song whose file is taken.
+var conflictNotes = map[ConflictPolicy]string{
+	ConflictSkip:      "(exists, will be skipped)",
+	ConflictOverwrite: "(exists, will be replaced)",
+	ConflictCompare:   "(exists, kept if better)",
+}
+
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	policy, ok := conflictPolicies[strings.ToLower(name)]
+	if !ok {
+		return ConflictRename, fmt.Errorf("Unknown conflict policy \"%s\", expected skip, overwrite, rename or compare.", name)
+	}
+
+	return policy, nil
+}
+
+// ConflictError is returned for songs that were not saved because their file
+// is taken.
+type ConflictError struct {
+	Path string
+	// Compared is set when the song was downloaded but the existing file was
+	// kept.
+	Compared bool
+}
+
+func (e *ConflictError) Error() string {
+	if e.Compared {
+		return fmt.Sprintf("Kept %s, the new download is not longer and has no higher bitrate.", e.Path)
+	}
+	return fmt.Sprintf("Skipped, %s already exists.", e.Path)
+}
+
+func isConflict(err error) bool {
+	var conflict *ConflictError
+	return errors.As(err, &conflict)
+}
+
+// pathClaims tracks files claimed by songs of this session, so collisions are
+// found before the songs are downloaded.
+type pathClaims struct {
+	mu     sync.Mutex
+	claims map[string]*claim
+}
+
+// claim is held by the song saved to a path. done is closed once the song
+// is either saved or gave up the path.
+type claim struct {
+	id   string
+	done chan struct{}
+}
+
+var claims = pathClaims{claims: make(map[string]*claim)}
+
+// reserve returns the file the song with the given ID is saved to, built
+// from fname and extension. Skipped songs return a ConflictError. With the
+// skip policy a song waits for the song holding its path, and is only
+// skipped when that one was saved.
+func (c *pathClaims) reserve(ctx context.Context, fname string, extension string, id string, policy ConflictPolicy) (string, error) {
+	path := fname + "." + extension
+
+	c.mu.Lock()
+	for policy == ConflictSkip {
+		owner, ok := c.claims[path]
+		if !ok || owner.id == id || owner.finished() {
+			break
+		}
+
+		c.mu.Unlock()
+		select {
+		case <-owner.done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		c.mu.Lock()
+	}
+	defer c.mu.Unlock()
+
+	if c.taken(path, id) {
+		switch policy {
+		case ConflictSkip:
+			return "", &ConflictError{Path: path}
+		case ConflictRename:
+			path = freePath(fname, extension, func(path string) bool { return c.taken(path, id) })
+		}
+	}
+
+	if owner, ok := c.claims[path]; !ok || owner.id != id {
+		c.claims[path] = &claim{id: id, done: make(chan struct{})}
+	}
+	return path, nil
+}
+
+// hold claims the file of a song confirmed for download, so the editor
+// preview of later songs sees it before the download starts. Unlike reserve
+// it never waits or fails: paths held by other songs, and files skipped with
+// the skip policy, are left for reserve to resolve.
+func (c *pathClaims) hold(fname string, extension string, id string, policy ConflictPolicy) {
+	path := fname + "." + extension
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.taken(path, id) {
+		switch policy {
+		case ConflictRename:
+			path = freePath(fname, extension, func(path string) bool { return c.taken(path, id) })
+		case ConflictSkip:
+			return
+		}
+	}
+
+	if _, ok := c.claims[path]; !ok {
+		c.claims[path] = &claim{id: id, done: make(chan struct{})}
+	}
+}
+
+// lookup returns the file reserve would pick for the song right now, without
+// reserving it, and whether another song or file already has that path.
+func (c *pathClaims) lookup(fname string, extension string, id string, policy ConflictPolicy) (string, bool) {
+	path := fname + "." + extension
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.taken(path, id) {
+		return path, false
+	}
+	if policy == ConflictRename {
+		return freePath(fname, extension, func(path string) bool { return c.taken(path, id) }), false
+	}
+
+	return path, true
+}
+
+// freePath returns the first name such as "Artist - Title (2)" that is not
+// taken.
+func freePath(fname string, extension string, taken func(path string) bool) string {
+	for n := 2; ; n++ {
+		if path := fmt.Sprintf("%s (%d).%s", fname, n, extension); !taken(path) {
+			return path
+		}
+	}
+}
+
+func (c *pathClaims) taken(path string, id string) bool {
+	if owner, ok := c.claims[path]; ok {
+		return owner.id != id
+	}
+
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// finish marks the claim of a saved song as done, keeping the path taken.
+func (c *pathClaims) finish(path string, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if owner, ok := c.claims[path]; ok && owner.id == id && !owner.finished() {
+		close(owner.done)
+	}
+}
+
+// release gives up the claim of a song that could not be saved.
+func (c *pathClaims) release(path string, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if owner, ok := c.claims[path]; ok && owner.id == id {
+		delete(c.claims, path)
+		if !owner.finished() {
+			close(owner.done)
+		}
+	}
+}
+
+func (cl *claim) finished() bool {
+	select {
+	case <-cl.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// place moves the converted song from tmp to path. With the compare policy
+// an existing file that is longer or has a higher bitrate than candidate is
+// kept and tmp is removed.
+func (c *pathClaims) place(ctx context.Context, tmp string, path string, policy ConflictPolicy, candidate audioInfo) error {
+	for {
+		// Probing can take a while, so it runs without holding the lock and
+		// is repeated if the file changed in the meantime.
+		probed, statErr := os.Stat(path)
+		var existing audioInfo
+		var probeErr error
+		if policy == ConflictCompare && statErr == nil {
+			existing, probeErr = probeAudio(ctx, path)
+		}
+
+		c.mu.Lock()
+		if policy == ConflictCompare {
+			current, err := os.Stat(path)
+			if !sameFile(probed, statErr, current, err) {
+				c.mu.Unlock()
+				if ctx.Err() != nil {
+					os.Remove(tmp)
+					return ctx.Err()
+				}
+				continue
+			}
+
+			// A file that can not be probed is kept, it may not be a song of ours.
+			if err == nil && (probeErr != nil || !candidate.better(existing)) {
+				c.mu.Unlock()
+				os.Remove(tmp)
+				return &ConflictError{Path: path, Compared: true}
+			}
+		}
+
+		err := os.Rename(tmp, path)
+		c.mu.Unlock()
+		if err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("Could not move song into %s: %v", path, err)
+		}
+
+		return nil
+	}
+}
+
+// sameFile reports whether two stat results of a path describe the same
+// unchanged file, or both found no file.
+func sameFile(a fs.FileInfo, aErr error, b fs.FileInfo, bErr error) bool {
+	if aErr != nil || bErr != nil {
+		return aErr != nil && bErr != nil
+	}
+
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// audioInfo describes the audio stream of a song file, leaving out tags and
+// cover art.
+type audioInfo struct {
+	duration time.Duration
+	// Bitrate in bits per second.
+	bitrate int
+}
+
+// better reports whether a is longer than b or, when both are about as long,
+// has a higher bitrate.
+func (a audioInfo) better(b audioInfo) bool {
+	// YouTube reports durations in whole seconds.
+	if diff := a.duration - b.duration; diff > time.Second || diff < -time.Second {
+		return diff > 0
+	}
+
+	return a.bitrate > b.bitrate
+}
+
+// downloadInfo estimates the audio of a song converted from source with the
+// given quality. Encoding at a higher bitrate than the source adds nothing.
+func downloadInfo(video *youtube.Video, source *youtube.Format, q Quality) audioInfo {
+	info := audioInfo{duration: video.Duration, bitrate: source.Bitrate}
+	if q.Bitrate > 0 && (info.bitrate <= 0 || q.Bitrate < info.bitrate) {
+		info.bitrate = q.Bitrate
+	}
+
+	return info
+}
+
+// probeAudio reads the duration and bitrate of an audio file. m4a files are
+// read directly, other formats need ffprobe.
+func probeAudio(ctx context.Context, path string) (audioInfo, error) {
+	if strings.EqualFold(filepath.Ext(path), ".m4a") {
+		return probeMP4(path)
+	}
+
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=duration,bit_rate:format=duration,bit_rate",
+		"-of", "default=noprint_wrappers=1", path).Output()
+	if err != nil {
+		return audioInfo{}, fmt.Errorf("Could not probe %s: %v", path, err)
+	}
+
+	info := parseFFprobe(out)
+	if info.duration <= 0 {
+		return audioInfo{}, fmt.Errorf("Could not read duration of %s.", path)
+	}
+
+	return info, nil
+}
+
+// parseFFprobe reads ffprobe key=value output. Stream values come first and
+// are preferred, since format bitrates include cover art.
+func parseFFprobe(out []byte) audioInfo {
+	var info audioInfo
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "duration":
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && info.duration == 0 {
+				info.duration = time.Duration(seconds * float64(time.Second))
+			}
+		case "bit_rate":
+			if bitrate, err := strconv.Atoi(value); err == nil && info.bitrate == 0 {
+				info.bitrate = bitrate
+			}
+		}
+	}
+
+	return info
+}
+
+// probeMP4 reads the audio track of an m4a file.
+func probeMP4(path string) (audioInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return audioInfo{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return audioInfo{}, err
+	}
+
+	track, err := readMP4Track(file, stat.Size())
+	if err != nil {
+		return audioInfo{}, err
+	}
+	if track.timescale == 0 || track.duration() == 0 {
+		return audioInfo{}, fmt.Errorf("Could not read duration of %s.", path)
+	}
+
+	var size uint64
+	for _, sample := range track.samples {
+		size += uint64(sample.size)
+	}
+
+	seconds := float64(track.duration()) / float64(track.timescale)
+	return audioInfo{
+		duration: time.Duration(seconds * float64(time.Second)),
+		bitrate:  int(float64(size*8) / seconds),
+	}, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	policy, err := ParseConflictPolicy("Compare")
+	require.NoError(t, err)
+	require.Equal(t, ConflictCompare, policy)
+
+	_, err = ParseConflictPolicy("merge")
+	require.Error(t, err)
+}
+
+func TestReserve(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "Artist - Title")
+	require.NoError(t, os.WriteFile(fname+".mp3", []byte("old"), 0644))
+
+	c := pathClaims{claims: make(map[string]*claim)}
+
+	_, err := c.reserve(context.Background(), fname, "mp3", "a", ConflictSkip)
+	require.True(t, isConflict(err))
+
+	path, err := c.reserve(context.Background(), fname, "mp3", "a", ConflictOverwrite)
+	require.NoError(t, err)
+	require.Equal(t, fname+".mp3", path)
+
+	// The same song keeps its claim, another one is renamed.
+	path, err = c.reserve(context.Background(), fname, "mp3", "a", ConflictSkip)
+	require.NoError(t, err)
+	require.Equal(t, fname+".mp3", path)
+
+	path, err = c.reserve(context.Background(), fname, "mp3", "b", ConflictRename)
+	require.NoError(t, err)
+	require.Equal(t, fname+" (2).mp3", path)
+
+	// Songs of the session conflict before their files exist.
+	path, err = c.reserve(context.Background(), fname, "mp3", "c", ConflictRename)
+	require.NoError(t, err)
+	require.Equal(t, fname+" (3).mp3", path)
+
+	c.release(fname+" (2).mp3", "b")
+	path, err = c.reserve(context.Background(), fname, "mp3", "d", ConflictRename)
+	require.NoError(t, err)
+	require.Equal(t, fname+" (2).mp3", path)
+}
+
+func TestHold(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "Artist - Title")
+	c := pathClaims{claims: make(map[string]*claim)}
+
+	// Confirmed songs are seen by the preview before they are downloaded.
+	c.hold(fname, "mp3", "a", ConflictRename)
+	c.hold(fname, "mp3", "b", ConflictRename)
+	path, taken := c.lookup(fname, "mp3", "c", ConflictRename)
+	require.Equal(t, fname+" (3).mp3", path)
+	require.False(t, taken)
+
+	_, taken = c.lookup(fname, "mp3", "c", ConflictSkip)
+	require.True(t, taken)
+
+	// Saving keeps the held file.
+	path, err := c.reserve(context.Background(), fname, "mp3", "b", ConflictRename)
+	require.NoError(t, err)
+	require.Equal(t, fname+" (2).mp3", path)
+
+	// Held paths are not taken over, the skip policy leaves them to reserve.
+	c.hold(fname, "mp3", "c", ConflictOverwrite)
+	c.hold(fname, "mp3", "d", ConflictSkip)
+	require.Equal(t, "a", c.claims[fname+".mp3"].id)
+	require.Len(t, c.claims, 2)
+}
+
+func TestReserveSkip(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "Artist - Title")
+	c := pathClaims{claims: make(map[string]*claim)}
+
+	path, err := c.reserve(context.Background(), fname, "mp3", "a", ConflictSkip)
+	require.NoError(t, err)
+
+	// The second song waits for the first one, which fails.
+	reserved := make(chan error)
+	go func() {
+		_, err := c.reserve(context.Background(), fname, "mp3", "b", ConflictSkip)
+		reserved <- err
+	}()
+	c.release(path, "a")
+	require.NoError(t, <-reserved)
+
+	// Once the second song is saved, the first one is skipped.
+	require.NoError(t, os.WriteFile(path, []byte("b"), 0644))
+	c.finish(path, "b")
+	_, err = c.reserve(context.Background(), fname, "mp3", "a", ConflictSkip)
+	require.True(t, isConflict(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.claims[path] = &claim{id: "c", done: make(chan struct{})}
+	cancel()
+	_, err = c.reserve(ctx, fname, "mp3", "d", ConflictSkip)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestPlace(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "song.m4a")
+	tmp := filepath.Join(dir, ".song.m4a")
+	c := pathClaims{claims: make(map[string]*claim)}
+
+	// Three AAC frames of 1024 samples at 44.1 kHz.
+	existing := fragmentedMP4([][]byte{[]byte("first"), []byte("second"), []byte("third")})
+	require.NoError(t, os.WriteFile(path, existing, 0644))
+
+	// Cover art makes the new file bigger, but it is not longer.
+	require.NoError(t, os.WriteFile(tmp, make([]byte, 2*len(existing)), 0644))
+	err := c.place(context.Background(), tmp, path, ConflictCompare, audioInfo{bitrate: 1000})
+	require.True(t, isConflict(err))
+	require.NoFileExists(t, tmp)
+
+	require.NoError(t, os.WriteFile(tmp, []byte("longer song"), 0644))
+	require.NoError(t, c.place(context.Background(), tmp, path, ConflictCompare, audioInfo{duration: 3 * time.Minute}))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "longer song", string(b))
+
+	// Files that can not be probed are kept.
+	require.NoError(t, os.WriteFile(tmp, []byte("new song"), 0644))
+	err = c.place(context.Background(), tmp, path, ConflictCompare, audioInfo{duration: 3 * time.Minute})
+	require.True(t, isConflict(err))
+
+	require.NoError(t, os.WriteFile(tmp, []byte("short"), 0644))
+	require.NoError(t, c.place(context.Background(), tmp, path, ConflictOverwrite, audioInfo{}))
+	b, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "short", string(b))
+
+	// A file replaced while it was probed is probed again.
+	probed, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(tmp, []byte("replaced"), 0644))
+	require.NoError(t, os.Rename(tmp, path))
+	current, err := os.Stat(path)
+	require.NoError(t, err)
+	require.False(t, sameFile(probed, nil, current, nil))
+	require.True(t, sameFile(current, nil, current, nil))
+
+	_, missing := os.Stat(tmp)
+	require.True(t, sameFile(nil, missing, nil, missing))
+	require.False(t, sameFile(nil, missing, current, nil))
+}
+
+func TestAudioInfo(t *testing.T) {
+	song := audioInfo{duration: 200 * time.Second, bitrate: 128000}
+
+	require.True(t, audioInfo{duration: 230 * time.Second, bitrate: 96000}.better(song))
+	require.True(t, audioInfo{duration: 200*time.Second + 500*time.Millisecond, bitrate: 160000}.better(song))
+	require.False(t, audioInfo{duration: 199 * time.Second, bitrate: 128000}.better(song))
+	require.False(t, audioInfo{duration: 150 * time.Second, bitrate: 320000}.better(song))
+
+	info := parseFFprobe([]byte("duration=200.5\nbit_rate=128000\nduration=200.6\nbit_rate=131000\n"))
+	require.Equal(t, audioInfo{duration: 200500 * time.Millisecond, bitrate: 128000}, info)
+
+	info = parseFFprobe([]byte("duration=N/A\nbit_rate=N/A\nduration=200.5\nbit_rate=131000\n"))
+	require.Equal(t, audioInfo{duration: 200500 * time.Millisecond, bitrate: 131000}, info)
+
+	video := &youtube.Video{Duration: 200 * time.Second}
+	require.Equal(t, 130000, downloadInfo(video, &youtube.Format{Bitrate: 130000}, defaultQuality).bitrate)
+	require.Equal(t, 96000, downloadInfo(video, &youtube.Format{Bitrate: 130000}, Quality{Bitrate: 96000}).bitrate)
+	require.Equal(t, 130000, downloadInfo(video, &youtube.Format{Bitrate: 130000}, Quality{Bitrate: 320000}).bitrate)
+}
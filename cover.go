@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// CoverMode decides whether and how a video thumbnail is embedded as cover.
+type CoverMode int
+
+const (
+	CoverSquare CoverMode = iota
+	CoverFull
+	CoverNone
+)
+
+var coverModes = map[string]CoverMode{
+	"square": CoverSquare,
+	"full":   CoverFull,
+	"none":   CoverNone,
+}
+
+func ParseCoverMode(name string) (CoverMode, error) {
+	mode, ok := coverModes[strings.ToLower(name)]
+	if !ok {
+		return CoverNone, fmt.Errorf("Unknown cover mode \"%s\", expected square, full or none.", name)
+	}
+
+	return mode, nil
+}
+
+// coverTimeout bounds the thumbnail download, so a stalled server can not
+// hold up a download worker.
+const coverTimeout = 20 * time.Second
+
+// FetchCover downloads the largest thumbnail of a video and returns it as
+// JPEG, cropped to a square when requested.
+func FetchCover(ctx context.Context, video *youtube.Video, mode CoverMode) ([]byte, error) {
+	if mode == CoverNone {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, coverTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, thumbnailURL(video), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not download thumbnail: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not download thumbnail: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Could not download thumbnail: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Could not download thumbnail: %v", err)
+	}
+
+	if mode == CoverFull {
+		return data, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode thumbnail: %v", err)
+	}
+
+	var b bytes.Buffer
+	if err = jpeg.Encode(&b, SquareCrop(img), &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("Could not encode cover: %v", err)
+	}
+
+	return b.Bytes(), nil
+}
+
+// thumbnailURL returns the URL of the largest JPEG thumbnail. WebP thumbnails
+// are skipped, because not every player can show them.
+func thumbnailURL(video *youtube.Video) string {
+	var best *youtube.Thumbnail
+	for i, t := range video.Thumbnails {
+		if strings.Contains(t.URL, ".webp") || strings.Contains(t.URL, "vi_webp") {
+			continue
+		}
+		if best == nil || t.Width*t.Height > best.Width*best.Height {
+			best = &video.Thumbnails[i]
+		}
+	}
+
+	if best == nil {
+		return fmt.Sprintf("https://i.ytimg.com/vi/%s/hqdefault.jpg", video.ID)
+	}
+
+	return best.URL
+}
+
+// SquareCrop removes black letterbox borders and crops the center square of
+// what is left.
+func SquareCrop(img image.Image) image.Image {
+	bounds := trimBorders(img)
+
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+	crop := image.Rect(x, y, x+side, y+side)
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, crop.Min, draw.Src)
+
+	return square
+}
+
+// trimBorders returns bounds of the image without dark rows and columns
+// along its edges.
+func trimBorders(img image.Image) image.Rectangle {
+	b := img.Bounds()
+
+	dark := func(x0, y0, x1, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				r, g, b, _ := img.At(x, y).RGBA()
+				// Luma of 16 out of 255 is still black on most screens.
+				if (299*r+587*g+114*b)/1000 > 16<<8 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	for b.Dy() > 1 && dark(b.Min.X, b.Min.Y, b.Max.X, b.Min.Y+1) {
+		b.Min.Y++
+	}
+	for b.Dy() > 1 && dark(b.Min.X, b.Max.Y-1, b.Max.X, b.Max.Y) {
+		b.Max.Y--
+	}
+	for b.Dx() > 1 && dark(b.Min.X, b.Min.Y, b.Min.X+1, b.Max.Y) {
+		b.Min.X++
+	}
+	for b.Dx() > 1 && dark(b.Max.X-1, b.Min.Y, b.Max.X, b.Max.Y) {
+		b.Max.X--
+	}
+
+	// A completely dark image has nothing worth keeping, use it as it is.
+	if b.Dx() <= 1 || b.Dy() <= 1 {
+		return img.Bounds()
+	}
+
+	return b
+}
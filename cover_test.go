@@ -0,0 +1,29 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSquareCrop(t *testing.T) {
+	// A 16:9 picture letterboxed into a 4:3 thumbnail, like hqdefault.jpg.
+	img := image.NewRGBA(image.Rect(0, 0, 480, 360))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 45, 480, 315), image.NewUniform(color.RGBA{200, 50, 50, 255}), image.Point{}, draw.Src)
+
+	square := SquareCrop(img)
+	require.Equal(t, image.Rect(0, 0, 270, 270), square.Bounds())
+
+	for _, p := range []image.Point{{0, 0}, {269, 0}, {0, 269}, {269, 269}} {
+		r, _, _, _ := square.At(p.X, p.Y).RGBA()
+		require.Equal(t, uint32(200), r>>8)
+	}
+
+	// Completely dark images are only cropped to the center.
+	dark := image.NewRGBA(image.Rect(0, 0, 160, 90))
+	require.Equal(t, image.Rect(0, 0, 90, 90), SquareCrop(dark).Bounds())
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/timer"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/muesli/reflow/indent"
+	"github.com/muesli/reflow/truncate"
+	"github.com/muesli/reflow/wordwrap"
+)
+
+// failure is a song that could not be downloaded or a link that could not
+// be fetched.
+type failure struct {
+	// Index of the song, -1 for links that were not fetched.
+	index int
+	name  string
+	err   string
+}
+
+func (f failure) retryable() bool {
+	return f.index >= 0
+}
+
+// failures lists failed downloads first, then failed fetches.
+func (m *model) failures() []failure {
+	failures := make([]failure, 0, m.failedCount+len(m.fetchErrors))
+	for i, status := range m.status {
+		if status != downloadFailed {
+			continue
+		}
+
+		f := failure{index: i, name: fmt.Sprintf("%s - %s", m.songs[i].Artist, m.songs[i].Title), err: "Unknown error."}
+		if i < len(m.results) && m.results[i].err != nil {
+			f.err = m.results[i].err.Error()
+		}
+		failures = append(failures, f)
+	}
+
+	for _, entry := range m.fetchErrors {
+		failures = append(failures, failure{index: -1, name: entry.Link, err: entry.Error})
+	}
+
+	return failures
+}
+
+// retryable returns indexes of songs that failed to download or were skipped.
+func (m *model) retryable() []int {
+	indexes := make([]int, 0, m.failedCount+m.skipCount)
+	for i, status := range m.status {
+		if status == downloadFailed || status == skipped {
+			indexes = append(indexes, i)
+		}
+	}
+
+	return indexes
+}
+
+// requeue queues a failed or skipped song for another download. With edit
+// it goes through the editor first. Schedule downloads afterwards.
+func (m *model) requeue(index int, edit bool) {
+	switch m.status[index] {
+	case downloadFailed:
+		m.failedCount -= 1
+	case skipped:
+		m.skipCount -= 1
+	default:
+		return
+	}
+
+	m.results[index] = downloadResult{}
+	delete(m.selected, index)
+	if edit {
+		m.status[index] = pending
+		m.editQueue = append(m.editQueue, index)
+	} else {
+		m.enqueue(index)
+	}
+	m.downloadPercent = float64(m.downloadCount+m.failedCount+m.skipCount) / float64(len(m.songs))
+}
+
+// newCountdown returns the timer that quits the finish view.
+func newCountdown() timer.Model {
+	return timer.NewWithInterval(time.Second*10, time.Second)
+}
+
+// finish shows the finish view. The countdown only starts when there are no
+// failed or skipped songs left to queue again.
+func (m *model) finish() tea.Cmd {
+	m.view = int(finish)
+	m.showFailures = false
+	m.finishIndx = 0
+	m.selected = make(map[int]bool)
+
+	if len(m.retryable()) > 0 {
+		return nil
+	}
+	return m.timer.Init()
+}
+
+// updateFailures handles keys while the failures panel is open.
+func updateFailures(msg tea.KeyMsg, m model) (tea.Model, tea.Cmd) {
+	failures := m.failures()
+
+	switch msg.String() {
+	case "up", "shift+tab":
+		if m.failureIndx > 0 {
+			m.failureIndx -= 1
+		}
+	case "down", "tab":
+		if m.failureIndx < len(failures)-1 {
+			m.failureIndx += 1
+		}
+	case "enter":
+		if m.failureIndx >= len(failures) || !failures[m.failureIndx].retryable() {
+			return m, nil
+		}
+
+		m.requeue(failures[m.failureIndx].index, false)
+		if m.failureIndx >= len(failures)-1 && m.failureIndx > 0 {
+			m.failureIndx -= 1
+		}
+
+		return m, m.scheduleDownloads()
+	}
+
+	return m, nil
+}
+
+// toggleFailures opens or closes the failures panel.
+func (m *model) toggleFailures() {
+	m.showFailures = !m.showFailures
+	m.failureIndx = 0
+}
+
+func failuresView(m model) string {
+	failures := m.failures()
+	if len(failures) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	if !m.showFailures {
+		b.WriteString(helpStyle(fmt.Sprintf("ctrl+f show %d failures", len(failures))) + "\n")
+		return b.String()
+	}
+
+	b.WriteString(barTextStyle("Failures.") + "\n")
+	for i, f := range failures {
+		name := truncate.StringWithTail(f.name, 60, "…")
+		if !f.retryable() {
+			name += helpStyle(" (fetch)")
+		}
+
+		if i != m.failureIndx {
+			b.WriteString("  " + failedStyle.Render(name) + "\n")
+			continue
+		}
+
+		b.WriteString(focusedStyle.Render("> "+name) + "\n")
+		b.WriteString(helpStyle(indent.String(wordwrap.String(f.err, maxWidth-4), 4)) + "\n")
+	}
+
+	b.WriteString(helpStyle("enter retry • ↑/↓ move • ctrl+f hide") + "\n")
+	return b.String()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailures(t *testing.T) {
+	m := model{
+		songs:       []Song{{Title: "Sandstorm", Artist: "Darude"}, {Title: "Around the World", Artist: "Daft Punk"}},
+		status:      []Status{downloaded, downloadFailed},
+		results:     []downloadResult{{}, {err: errors.New("Could not create source file.")}},
+		fetchErrors: []ReportEntry{fetchReport("https://youtu.be/a", errors.New("Video unavailable"))},
+		failedCount: 1,
+	}
+
+	failures := m.failures()
+	require.Equal(t, []failure{
+		{index: 1, name: "Daft Punk - Around the World", err: "Could not create source file."},
+		{index: -1, name: "https://youtu.be/a", err: "Video unavailable"},
+	}, failures)
+	require.True(t, failures[0].retryable())
+	require.False(t, failures[1].retryable())
+
+	m.requeue(1, false)
+	require.Equal(t, queued, m.status[1])
+	require.Equal(t, []int{1}, m.queue)
+	require.Equal(t, 0, m.failedCount)
+	require.Len(t, m.failures(), 1)
+
+	// Songs that did not fail are not queued again.
+	m.requeue(0, false)
+	require.Equal(t, []int{1}, m.queue)
+}
+
+func TestRequeueThroughEditor(t *testing.T) {
+	m := model{
+		songs:         []Song{{Title: "Sandstorm"}, {Title: "Around the World"}, {Title: "Strobe"}},
+		status:        []Status{skipped, downloaded, downloadFailed},
+		results:       make([]downloadResult, 3),
+		inputs:        newInputs(),
+		editIndx:      3,
+		skipCount:     1,
+		failedCount:   1,
+		downloadCount: 1,
+	}
+
+	require.Equal(t, []int{0, 2}, m.retryable())
+
+	m.requeue(0, true)
+	m.requeue(2, true)
+	require.Equal(t, 0, m.skipCount+m.failedCount)
+	require.False(t, m.done())
+
+	// Songs sent back are edited in order, then the editor is done again.
+	m.nextEdit()
+	require.Equal(t, 0, m.editIndx)
+	m.status[0] = queued
+	m.nextEdit()
+	require.Equal(t, 2, m.editIndx)
+	m.status[2] = queued
+	m.nextEdit()
+	require.Equal(t, 3, m.editIndx)
+	require.Equal(t, 1.0, m.editPercent)
+	require.Empty(t, m.retryable())
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+)
+
+// field is a song attribute editable in the metadata editor.
+type field struct {
+	name     string
+	get      func(s *Song) string
+	set      func(s *Song, value string)
+	validate func(value string) error
+}
+
+var fields = []field{
+	{
+		name: "Title",
+		get:  func(s *Song) string { return s.Title },
+		set:  func(s *Song, v string) { s.Title = v },
+		validate: func(v string) error {
+			if v == "" {
+				return fmt.Errorf("Title can not be empty.")
+			}
+			return nil
+		},
+	},
+	{
+		name: "Artist",
+		get:  func(s *Song) string { return s.Artist },
+		set:  func(s *Song, v string) { s.Artist = v },
+		validate: func(v string) error {
+			if v == "" {
+				return fmt.Errorf("Artist can not be empty.")
+			}
+			return nil
+		},
+	},
+	{
+		name: "Album",
+		get:  func(s *Song) string { return s.Album },
+		set:  func(s *Song, v string) { s.Album = v },
+	},
+	{
+		name: "Album Artist",
+		get:  func(s *Song) string { return s.AlbumArtist },
+		set:  func(s *Song, v string) { s.AlbumArtist = v },
+	},
+	{
+		name:     "Year",
+		get:      func(s *Song) string { return formatNumber(s.Year) },
+		set:      func(s *Song, v string) { s.Year, _ = strconv.Atoi(v) },
+		validate: validateNumber("Year", 1, 9999),
+	},
+	{
+		name: "Genre",
+		get:  func(s *Song) string { return s.Genre },
+		set:  func(s *Song, v string) { s.Genre = v },
+	},
+	{
+		name:     "Track",
+		get:      func(s *Song) string { return formatNumber(s.TrackNumber) },
+		set:      func(s *Song, v string) { s.TrackNumber, _ = strconv.Atoi(v) },
+		validate: validateNumber("Track", 1, 65535),
+	},
+}
+
+// newInputs creates a text input for every editable field, with the first
+// one focused.
+func newInputs() []textinput.Model {
+	inputs := make([]textinput.Model, len(fields))
+	for i, f := range fields {
+		input := textinput.New()
+		input.CursorStyle = cursorStyle
+		input.CharLimit = 64
+		input.Placeholder = f.name
+		input.Prompt = fmt.Sprintf("%-13s", f.name+":")
+
+		if i == 0 {
+			input.PromptStyle = focusedStyle
+			input.TextStyle = focusedStyle
+			input.Focus()
+		}
+
+		inputs[i] = input
+	}
+
+	return inputs
+}
+
+// loadInputs fills the inputs with values of the given song.
+func (m *model) loadInputs(index int) {
+	for i, f := range fields {
+		m.inputs[i].SetValue(f.get(&m.songs[index]))
+		m.inputs[i].CursorEnd()
+	}
+}
+
+// previewSong returns the edited song with the current input values, which
+// may not be valid yet.
+func (m *model) previewSong() Song {
+	song := m.songs[m.editIndx]
+	for i, f := range fields {
+		f.set(&song, strings.TrimSpace(m.inputs[i].Value()))
+	}
+
+	return song
+}
+
+// storeInputs validates the inputs and saves them into the given song.
+func (m *model) storeInputs(index int) error {
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		values[i] = strings.TrimSpace(m.inputs[i].Value())
+		if f.validate == nil {
+			continue
+		}
+		if err := f.validate(values[i]); err != nil {
+			return err
+		}
+	}
+
+	for i, f := range fields {
+		f.set(&m.songs[index], values[i])
+	}
+
+	return nil
+}
+
+// fieldValidator returns the validation of the field with the given name,
+// which accepts anything when the field has none.
+func fieldValidator(name string) func(string) error {
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) && f.validate != nil {
+			return f.validate
+		}
+	}
+
+	return func(string) error { return nil }
+}
+
+// validateNumber accepts empty values and whole numbers within the range.
+func validateNumber(name string, min int, max int) func(string) error {
+	return func(v string) error {
+		if v == "" {
+			return nil
+		}
+
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max {
+			return fmt.Errorf("%s must be a number between %d and %d.", name, min, max)
+		}
+		return nil
+	}
+}
+
+func formatNumber(n int) string {
+	if n == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreInputs(t *testing.T) {
+	m := model{
+		songs:  []Song{{Title: "Ledena", Artist: "Siddharta", Year: 2001}},
+		inputs: newInputs(),
+	}
+	m.loadInputs(0)
+	require.Equal(t, "2001", m.inputs[4].Value())
+	require.Equal(t, "", m.inputs[6].Value())
+
+	m.inputs[4].SetValue("two thousand")
+	require.Error(t, m.storeInputs(0))
+	require.Equal(t, 2001, m.songs[0].Year)
+
+	m.inputs[2].SetValue("Infra")
+	m.inputs[4].SetValue("2001")
+	m.inputs[6].SetValue("3")
+	require.NoError(t, m.storeInputs(0))
+	require.Equal(t, "Infra", m.songs[0].Album)
+	require.Equal(t, 3, m.songs[0].TrackNumber)
+
+	m.inputs[0].SetValue("  ")
+	require.Error(t, m.storeInputs(0))
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	id3 "github.com/bogem/id3v2"
+	"github.com/kkdai/youtube/v2"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	mimeAAC  = "audio/mp4; codecs=\"mp4a.40.2\""
+	mimeOpus = "audio/webm; codecs=\"opus\""
+)
+
+// Encoder converts a downloaded source stream into an audio file.
+type Encoder interface {
+	Encode(ctx context.Context, input string, output string, source *youtube.Format, q Quality) error
+}
+
+// Tagger writes song metadata into an encoded audio file.
+type Tagger interface {
+	Tag(ctx context.Context, path string, s *Song, q Quality, cover []byte) error
+}
+
+// Quality holds encoder settings chosen by the user. The zero value keeps
+// ffmpeg's defaults.
+type Quality struct {
+	// Constant bitrate in bits per second.
+	Bitrate int
+	// LAME variable bitrate preset, 0 (best) to 9, or -1 when unset.
+	VBR int
+}
+
+var defaultQuality = Quality{VBR: -1}
+
+func (q Quality) String() string {
+	switch {
+	case q.Bitrate > 0:
+		return fmt.Sprintf("CBR %dk", q.Bitrate/1000)
+	case q.VBR >= 0:
+		return fmt.Sprintf("VBR V%d", q.VBR)
+	default:
+		return ""
+	}
+}
+
+// args returns ffmpeg arguments applying the quality settings.
+func (q Quality) args() []string {
+	switch {
+	case q.Bitrate > 0:
+		return []string{"-b:a", fmt.Sprintf("%dk", q.Bitrate/1000)}
+	case q.VBR >= 0:
+		return []string{"-q:a", strconv.Itoa(q.VBR)}
+	default:
+		return nil
+	}
+}
+
+// ParseQuality parses bitrate such as "320k" and VBR preset such as "V0"
+// flags. Only one of them can be set.
+func ParseQuality(bitrate string, vbr string) (Quality, error) {
+	q := defaultQuality
+	if bitrate != "" && vbr != "" {
+		return q, fmt.Errorf("Bitrate and quality can not be used together.")
+	}
+
+	if bitrate != "" {
+		kbps, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(bitrate), "k"))
+		if err != nil || kbps < 8 || kbps > 512 {
+			return q, fmt.Errorf("Invalid bitrate \"%s\", expected a value such as 320k.", bitrate)
+		}
+		q.Bitrate = kbps * 1000
+	}
+
+	if vbr != "" {
+		preset, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(vbr), "V"))
+		if err != nil || preset < 0 || preset > 9 {
+			return q, fmt.Errorf("Invalid quality \"%s\", expected V0 to V9.", vbr)
+		}
+		q.VBR = preset
+	}
+
+	return q, nil
+}
+
+// OutputFormat describes how songs are saved for one audio file extension.
+type OutputFormat struct {
+	Extension string
+	// Source mime types in order of preference.
+	Sources []string
+	Encoder Encoder
+	Tagger  Tagger
+	Quality Quality
+	// Preference used to choose between available source streams.
+	Preference SourcePreference
+	Cover      CoverMode
+	// Template names the song file inside the output folder.
+	Template Template
+	// Conflict decides what happens when the song file is taken.
+	Conflict ConflictPolicy
+}
+
+var outputFormats = map[string]OutputFormat{
+	"mp3": {
+		Extension: "mp3",
+		Sources:   []string{mimeAAC},
+		Encoder:   ffmpegEncoder{codec: "libmp3lame"},
+		Tagger:    id3Tagger{},
+	},
+	"m4a": {
+		Extension: "m4a",
+		Sources:   []string{mimeAAC},
+		Encoder:   mp4Remuxer{fallback: ffmpegEncoder{codec: "aac"}},
+		Tagger:    mp4Tagger{},
+	},
+	"opus": {
+		Extension: "opus",
+		Sources:   []string{mimeOpus, mimeAAC},
+		Encoder:   ffmpegEncoder{codec: "libopus", copy: []string{mimeOpus}},
+		Tagger:    ffmpegTagger{},
+	},
+	"ogg": {
+		Extension: "ogg",
+		Sources:   []string{mimeOpus, mimeAAC},
+		Encoder:   ffmpegEncoder{codec: "libvorbis"},
+		Tagger:    ffmpegTagger{},
+	},
+	"flac": {
+		Extension: "flac",
+		Sources:   []string{mimeOpus, mimeAAC},
+		Encoder:   ffmpegEncoder{codec: "flac"},
+		Tagger:    ffmpegTagger{},
+	},
+	"aac": {
+		Extension: "aac",
+		Sources:   []string{mimeAAC},
+		Encoder:   adtsExtractor{fallback: ffmpegEncoder{codec: "aac"}},
+		Tagger:    id3Tagger{},
+	},
+}
+
+// lookupFormat returns the output format registered under the given name.
+func lookupFormat(formats map[string]OutputFormat, name string) (OutputFormat, error) {
+	format, ok := formats[strings.ToLower(name)]
+	format.Quality = defaultQuality
+	if !ok {
+		names := make([]string, 0, len(formats))
+		for name := range formats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return OutputFormat{}, fmt.Errorf("Unknown output format \"%s\", expected one of: %s.", name, strings.Join(names, ", "))
+	}
+
+	return format, nil
+}
+
+// WithQuality returns the format using the given encoder settings.
+func (f OutputFormat) WithQuality(q Quality) (OutputFormat, error) {
+	if q.VBR >= 0 && f.Extension != "mp3" {
+		return f, fmt.Errorf("Quality presets are only supported by mp3.")
+	}
+	if q.Bitrate > 0 && f.Extension == "flac" {
+		return f, fmt.Errorf("Bitrate can not be set for lossless flac.")
+	}
+
+	// Copied streams keep their bitrate, so a bitrate needs the encoder.
+	if q.Bitrate > 0 {
+		var fallback Encoder
+		switch e := f.Encoder.(type) {
+		case mp4Remuxer:
+			fallback = e.fallback
+		case adtsExtractor:
+			fallback = e.fallback
+		case ffmpegEncoder:
+			e.copy = nil
+			fallback = e
+		default:
+			fallback = f.Encoder
+		}
+		if fallback == nil {
+			return f, fmt.Errorf("Bitrate of %s files can only be set with ffmpeg, without it the stream is copied as is.", f.Extension)
+		}
+		f.Encoder = fallback
+	}
+
+	f.Quality = q
+	return f, nil
+}
+
+// BitrateWarning returns a warning when the chosen bitrate is higher than the
+// bitrate of the source stream, which only makes files bigger.
+func (f OutputFormat) BitrateWarning(source *youtube.Format) string {
+	if source == nil || source.Bitrate <= 0 || f.Quality.Bitrate <= source.Bitrate {
+		return ""
+	}
+
+	return fmt.Sprintf("Bitrate %dk is higher than the source bitrate of %dk.", f.Quality.Bitrate/1000, source.Bitrate/1000)
+}
+
+// ffmpegEncoder transcodes the source with the given ffmpeg audio codec, or
+// copies the audio stream when the source is already in a compatible codec.
+type ffmpegEncoder struct {
+	codec string
+	copy  []string
+}
+
+func (e ffmpegEncoder) Encode(ctx context.Context, input string, output string, source *youtube.Format, q Quality) error {
+	return runFFmpeg(ctx, e.args(input, output, source, q)...)
+}
+
+func (e ffmpegEncoder) args(input string, output string, source *youtube.Format, q Quality) []string {
+	args := []string{"-y", "-i", input, "-vn"}
+	if source != nil && slices.Contains(e.copy, source.MimeType) {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(append(args, "-c:a", e.codec), q.args()...)
+	}
+
+	return append(args, output)
+}
+
+// mp4Remuxer moves the AAC stream of an mp4 source into a regular m4a file
+// without ffmpeg. Sources in other codecs are passed to the fallback encoder.
+type mp4Remuxer struct {
+	fallback Encoder
+}
+
+func (e mp4Remuxer) Encode(ctx context.Context, input string, output string, source *youtube.Format, q Quality) error {
+	if source == nil || !isMP4AAC(source.MimeType) {
+		return encodeFallback(ctx, e.fallback, input, output, source, q)
+	}
+
+	return rewriteM4A(input, output, mp4Metadata{})
+}
+
+// adtsExtractor copies the AAC stream of an mp4 source into a raw ADTS file
+// without ffmpeg. Sources in other codecs are passed to the fallback encoder.
+type adtsExtractor struct {
+	fallback Encoder
+}
+
+func (e adtsExtractor) Encode(ctx context.Context, input string, output string, source *youtube.Format, q Quality) error {
+	if source == nil || !isMP4AAC(source.MimeType) {
+		return encodeFallback(ctx, e.fallback, input, output, source, q)
+	}
+
+	return extractADTS(input, output)
+}
+
+// encodeFallback encodes sources the pure Go encoders can not copy. Without
+// ffmpeg there is no fallback.
+func encodeFallback(ctx context.Context, fallback Encoder, input string, output string, source *youtube.Format, q Quality) error {
+	if fallback == nil {
+		mimeType := "unknown"
+		if source != nil {
+			mimeType = source.MimeType
+		}
+		return fmt.Errorf("Converting %s source needs ffmpeg.", mimeType)
+	}
+
+	return fallback.Encode(ctx, input, output, source, q)
+}
+
+// isMP4AAC reports whether the mime type describes an mp4 container with AAC
+// audio, either audio only or muxed with video.
+func isMP4AAC(mimeType string) bool {
+	container, codecs, _ := strings.Cut(mimeType, ";")
+	return strings.HasSuffix(container, "/mp4") && strings.Contains(codecs, "mp4a.")
+}
+
+// mp4Tagger rewrites an m4a file with iTunes metadata atoms.
+type mp4Tagger struct{}
+
+func (mp4Tagger) Tag(ctx context.Context, path string, s *Song, q Quality, cover []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), ".tag-"+filepath.Base(path))
+	defer os.Remove(tmp)
+
+	meta := mp4Metadata{
+		Title:       s.Title,
+		Artist:      s.Artist,
+		Album:       s.Album,
+		AlbumArtist: s.AlbumArtist,
+		Year:        s.Year,
+		Genre:       s.Genre,
+		TrackNumber: s.TrackNumber,
+		Comment:     s.URL(),
+		Cover:       cover,
+	}
+	if err := rewriteM4A(path, tmp, meta); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("Could not save edited metadata.")
+	}
+
+	return nil
+}
+
+// extractADTS reads the audio track of an mp4 file and writes its samples
+// as ADTS frames.
+func extractADTS(input string, output string) error {
+	src, err := os.Open(input)
+	if err != nil {
+		return fmt.Errorf("Could not open mp4 file.")
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("Could not open mp4 file.")
+	}
+
+	track, err := readMP4Track(src, info.Size())
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("Could not create aac file.")
+	}
+
+	w := bufio.NewWriter(dst)
+	err = writeADTS(w, src, track)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// rewriteM4A reads the audio track of an mp4 file and writes it into a
+// regular m4a file with the given metadata.
+func rewriteM4A(input string, output string, meta mp4Metadata) error {
+	src, err := os.Open(input)
+	if err != nil {
+		return fmt.Errorf("Could not open mp4 file.")
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("Could not open mp4 file.")
+	}
+
+	track, err := readMP4Track(src, info.Size())
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("Could not create m4a file.")
+	}
+
+	w := bufio.NewWriter(dst)
+	err = writeM4A(w, src, track, meta)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// id3Tagger writes ID3v2 frames used by mp3 files.
+type id3Tagger struct{}
+
+func (id3Tagger) Tag(ctx context.Context, path string, s *Song, q Quality, cover []byte) error {
+	tag, err := id3.Open(path, id3.Options{Parse: true})
+	if err != nil {
+		return fmt.Errorf("Could not open mp3 file to edit metadata.")
+	}
+	defer tag.Close()
+
+	tag.SetArtist(s.Artist)
+	tag.SetTitle(s.Title)
+	if s.Album != "" {
+		tag.SetAlbum(s.Album)
+	}
+	if s.AlbumArtist != "" {
+		tag.AddTextFrame(tag.CommonID("Band/Orchestra/Accompaniment"), id3.EncodingUTF8, s.AlbumArtist)
+	}
+	if s.Year > 0 {
+		tag.SetYear(strconv.Itoa(s.Year))
+	}
+	if s.Genre != "" {
+		tag.SetGenre(s.Genre)
+	}
+	if s.TrackNumber > 0 {
+		tag.AddTextFrame(tag.CommonID("Track number/Position in set"), id3.EncodingUTF8, strconv.Itoa(s.TrackNumber))
+	}
+
+	// Keep the source of the song, so the file can be traced back to it.
+	tag.AddCommentFrame(id3.CommentFrame{
+		Encoding:    id3.EncodingUTF8,
+		Language:    "eng",
+		Description: "Source",
+		Text:        s.URL(),
+	})
+	tag.AddFrame("WXXX", userURLFrame{
+		Encoding:    id3.EncodingISO,
+		Description: "Source",
+		URL:         s.URL(),
+	})
+
+	if settings := q.String(); settings != "" {
+		tag.AddTextFrame(tag.CommonID("Software/Hardware and settings used for encoding"), id3.EncodingUTF8, settings)
+	}
+	if len(cover) > 0 {
+		tag.AddAttachedPicture(id3.PictureFrame{
+			Encoding:    id3.EncodingUTF8,
+			MimeType:    http.DetectContentType(cover),
+			PictureType: id3.PTFrontCover,
+			Description: "Front cover",
+			Picture:     cover,
+		})
+	}
+
+	if err = tag.Save(); err != nil {
+		return fmt.Errorf("Could not save edited metadata.")
+	}
+
+	return nil
+}
+
+// userURLFrame is a WXXX frame, a link with a description. id3v2 has no type
+// for it. Only ISO-8859-1 and UTF-8 are supported, as they do not need the
+// text to be converted, and the URL itself is always ISO-8859-1.
+type userURLFrame struct {
+	Encoding    id3.Encoding
+	Description string
+	URL         string
+}
+
+func (f userURLFrame) Size() int {
+	return 1 + len(f.Description) + len(f.Encoding.TerminationBytes) + len(f.URL)
+}
+
+func (f userURLFrame) UniqueIdentifier() string {
+	return f.Description
+}
+
+func (f userURLFrame) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	b.WriteByte(f.Encoding.Key)
+	b.WriteString(f.Description)
+	b.Write(f.Encoding.TerminationBytes)
+	b.WriteString(f.URL)
+
+	return b.WriteTo(w)
+}
+
+// ffmpegTagger rewrites the file with ffmpeg, which stores metadata in the
+// container's own format: iTunes atoms for m4a and Vorbis comments for flac,
+// ogg and opus.
+type ffmpegTagger struct{}
+
+func (ffmpegTagger) Tag(ctx context.Context, path string, s *Song, q Quality, cover []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), ".tag-"+filepath.Base(path))
+	defer os.Remove(tmp)
+
+	args := []string{"-y", "-i", path, "-map", "0", "-c", "copy",
+		"-metadata", "title=" + s.Title,
+		"-metadata", "artist=" + s.Artist,
+		"-metadata", "album=" + s.Album,
+		"-metadata", "album_artist=" + s.AlbumArtist,
+		"-metadata", "genre=" + s.Genre,
+		"-metadata", "comment=" + s.URL()}
+	if s.Year > 0 {
+		args = append(args, "-metadata", fmt.Sprintf("date=%d", s.Year))
+	}
+	if s.TrackNumber > 0 {
+		args = append(args, "-metadata", fmt.Sprintf("track=%d", s.TrackNumber))
+	}
+	if settings := q.String(); settings != "" {
+		args = append(args, "-metadata", "encoder_settings="+settings)
+	}
+
+	err := runFFmpeg(ctx, append(args, tmp)...)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("Could not save edited metadata.")
+	}
+
+	return nil
+}
+
+// runFFmpeg runs ffmpeg until it finishes or ctx is cancelled, which kills it.
+func runFFmpeg(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%v: %s", err, stderr.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	id3 "github.com/bogem/id3v2"
+	"github.com/kkdai/youtube/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuality(t *testing.T) {
+	q, err := ParseQuality("320k", "")
+	require.NoError(t, err)
+	require.Equal(t, Quality{Bitrate: 320000, VBR: -1}, q)
+	require.Equal(t, []string{"-b:a", "320k"}, q.args())
+
+	q, err = ParseQuality("", "V0")
+	require.NoError(t, err)
+	require.Equal(t, Quality{VBR: 0}, q)
+	require.Equal(t, []string{"-q:a", "0"}, q.args())
+
+	q, err = ParseQuality("", "")
+	require.NoError(t, err)
+	require.Empty(t, q.args())
+
+	for _, flags := range [][2]string{{"320k", "V0"}, {"loud", ""}, {"", "V10"}} {
+		_, err = ParseQuality(flags[0], flags[1])
+		require.Error(t, err)
+	}
+}
+
+func TestBitrateWarning(t *testing.T) {
+	mp3, err := ffmpegBackend{}.OutputFormat("mp3")
+	require.NoError(t, err)
+
+	mp3, err = mp3.WithQuality(Quality{Bitrate: 320000, VBR: -1})
+	require.NoError(t, err)
+	require.NotEmpty(t, mp3.BitrateWarning(&youtube.Format{Bitrate: 130000}))
+	require.Empty(t, mp3.BitrateWarning(&youtube.Format{Bitrate: 320000}))
+
+	flac, err := ffmpegBackend{}.OutputFormat("flac")
+	require.NoError(t, err)
+
+	_, err = flac.WithQuality(Quality{VBR: 0})
+	require.Error(t, err)
+}
+
+func TestWithQualityCopiedStreams(t *testing.T) {
+	// A bitrate makes ffmpeg encode streams that are otherwise copied.
+	m4a, err := ffmpegBackend{}.OutputFormat("m4a")
+	require.NoError(t, err)
+	m4a, err = m4a.WithQuality(Quality{Bitrate: 256000, VBR: -1})
+	require.NoError(t, err)
+	require.Equal(t, ffmpegEncoder{codec: "aac"}, m4a.Encoder)
+
+	m4a, err = goBackend{}.OutputFormat("m4a")
+	require.NoError(t, err)
+	_, err = m4a.WithQuality(Quality{Bitrate: 256000, VBR: -1})
+	require.ErrorContains(t, err, "ffmpeg")
+
+	aac, err := goBackend{}.OutputFormat("aac")
+	require.NoError(t, err)
+	aac, err = aac.WithQuality(defaultQuality)
+	require.NoError(t, err)
+	require.Equal(t, adtsExtractor{}, aac.Encoder)
+
+	// Opus sources are copied into opus files unless a bitrate is set.
+	opus, err := ffmpegBackend{}.OutputFormat("opus")
+	require.NoError(t, err)
+	source := &youtube.Format{MimeType: mimeOpus}
+	require.Contains(t, opus.Encoder.(ffmpegEncoder).args("in", "out", source, defaultQuality), "copy")
+
+	q := Quality{Bitrate: 96000, VBR: -1}
+	opus, err = opus.WithQuality(q)
+	require.NoError(t, err)
+	args := opus.Encoder.(ffmpegEncoder).args("in", "out", source, q)
+	require.NotContains(t, args, "copy")
+	require.Subset(t, args, []string{"-c:a", "libopus", "-b:a", "96k"})
+}
+
+func TestUserURLFrame(t *testing.T) {
+	tag := id3.NewEmptyTag()
+	tag.AddFrame("WXXX", userURLFrame{Encoding: id3.EncodingISO, Description: "Source", URL: "https://youtu.be/abc"})
+
+	var b bytes.Buffer
+	_, err := tag.WriteTo(&b)
+	require.NoError(t, err)
+
+	parsed, err := id3.ParseReader(&b, id3.Options{Parse: true})
+	require.NoError(t, err)
+	frames := parsed.GetFrames("WXXX")
+	require.Len(t, frames, 1)
+	require.Equal(t, []byte("\x00Source\x00https://youtu.be/abc"), frames[0].(id3.UnknownFrame).Body)
+}
@@ -3,16 +3,44 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"net/url"
 	"os"
 	"strings"
 
 	"github.com/kkdai/youtube/v2"
 )
 
-func getLinks(client *youtube.Client, source string, nLinks int, skip int) (links []string, err error) {
+// Album describes the playlist links were taken from. It is empty for links
+// read from a file.
+type Album struct {
+	Title string
+	// Playlist position of each link, counting from 1.
+	Tracks map[string]int
+}
+
+// playlistID returns the list parameter of a playlist link, or "" for other
+// sources.
+func playlistID(source string) string {
+	if !strings.Contains(source, "youtube.com") {
+		return ""
+	}
+
+	u, err := url.Parse(source)
+	if err != nil {
+		return ""
+	}
+
+	return u.Query().Get("list")
+}
+
+func getLinks(client *youtube.Client, source string, nLinks int, skip int) (links []string, album Album, err error) {
 	isPlaylist := strings.Contains(source, "youtube.com")
 	if isPlaylist {
-		links, err = fetchPlaylistLinks(client, source)
+		links, album.Title, err = fetchPlaylistLinks(client, source)
+		album.Tracks = make(map[string]int, len(links))
+		for i, link := range links {
+			album.Tracks[link] = i + 1
+		}
 	} else {
 		links, err = readLinks(source)
 	}
@@ -21,8 +49,13 @@ func getLinks(client *youtube.Client, source string, nLinks int, skip int) (link
 		return
 	}
 
-	if nLinks != 0 {
-		links = links[skip : nLinks+skip]
+	if skip > 0 || nLinks > 0 {
+		start := min(max(skip, 0), len(links))
+		end := len(links)
+		if nLinks > 0 {
+			end = min(start+nLinks, len(links))
+		}
+		links = links[start:end]
 	}
 
 	return
@@ -56,10 +89,10 @@ func readLinks(path string) ([]string, error) {
 	return links, nil
 }
 
-func fetchPlaylistLinks(client *youtube.Client, link string) ([]string, error) {
+func fetchPlaylistLinks(client *youtube.Client, link string) ([]string, string, error) {
 	playlist, err := client.GetPlaylist(link)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	links := make([]string, 0, len(playlist.Videos))
@@ -67,5 +100,5 @@ func fetchPlaylistLinks(client *youtube.Client, link string) ([]string, error) {
 		links = append(links, fmt.Sprintf("https://www.youtube.com/watch?v=%s", video.ID))
 	}
 
-	return links, nil
+	return links, playlist.Title, nil
 }
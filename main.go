@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/charmbracelet/bubbles/progress"
@@ -12,13 +17,23 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/kkdai/youtube/v2"
+	"golang.org/x/exp/slices"
 )
 
 var client youtube.Client = youtube.Client{}
+var program *tea.Program
 var nLinks *int
 var skip *int
+var fetchWorkers *int
+var downloadWorkers *int
+var archivePath *string
+var sessionPath string
 var source string
 var output string
+var outputFormat OutputFormat
+var reportCSV *bool
+
+var commands = []string{"sync", "plan", "apply"}
 
 var (
 	focusedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
@@ -41,57 +56,233 @@ var (
 
 func main() {
 
+	// The sync subcommand mirrors a whole playlist into the output folder,
+	// plan and apply split editing metadata from downloading.
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && slices.Contains(commands, args[0]) {
+		command = args[0]
+		args = args[1:]
+	}
+	syncMode := command == "sync"
+
 	nLinks = flag.Int("n_links", 0, "Download first given number of youtube links.")
 	skip = flag.Int("skip", 0, "Skip first number of youtube links.")
-	flag.Parse()
+	fetchWorkers = flag.Int("fetch_workers", 4, "Number of videos to fetch metadata for in parallel.")
+	downloadWorkers = flag.Int("download_workers", 2, "Number of songs to download and convert in parallel.")
+	formatName := flag.String("format", "mp3", "Output audio format: mp3, m4a, aac, opus, ogg or flac.")
+	backendName := flag.String("backend", "auto", "Conversion backend: ffmpeg, go (m4a and aac only, no ffmpeg needed) or auto.")
+	bitrate := flag.String("bitrate", "", "Constant encoding bitrate, e.g. 320k.")
+	vbr := flag.String("quality", "", "Variable bitrate mp3 quality from V0 (best) to V9.")
+	preference := flag.String("source", "codec", "Preferred source stream: codec (avoid transcoding), quality or size.")
+	archivePath = flag.String("archive", "", "Archive of downloaded videos, skipped in later runs (default {output_folder}/archive.json).")
+	cover := flag.String("cover", "square", "Embed video thumbnail as cover art: square, full or none.")
+	template := flag.String("template", defaultTemplate, "Path of songs inside the output folder built from {title}, {artist}, {album}, {album_artist}, {genre}, {year}, {track} and {id}, e.g. {artist}/{album}/{track:02} - {title}.")
+	var batch bool
+	flag.BoolVar(&batch, "batch", false, "Download without the editor, printing plain text progress.")
+	flag.BoolVar(&batch, "yes", false, "Same as -batch.")
+	accept := flag.String("accept", "reliable", "With -batch, download songs parsed as reliable, maybe or all, the rest is written to "+reviewFile+".")
+	filenames := flag.String("filenames", defaultFilenameProfile(), "File name rules of the output folder's filesystem: posix, windows or fat32 (USB sticks).")
+	onConflict := flag.String("on_conflict", "rename", "When a song file already exists: skip, overwrite, rename (append \" (2)\") or compare (keep the longer or higher bitrate file).")
+	reportCSV = flag.Bool("report_csv", false, "Also write the run report as report.csv next to report.json.")
+	resume := flag.String("resume", "", "Continue the session saved in the given session file.")
+	moveRemoved := flag.Bool("move_removed", false, "With sync, move songs removed from the playlist into the "+removedFolder+" folder.")
+	flag.CommandLine.Parse(args)
+
+	backend, err := SelectBackend(*backendName)
+	if err == nil {
+		outputFormat, err = backend.OutputFormat(*formatName)
+	}
+	if err == nil {
+		var quality Quality
+		if quality, err = ParseQuality(*bitrate, *vbr); err == nil {
+			outputFormat, err = outputFormat.WithQuality(quality)
+		}
+	}
+	if err == nil {
+		outputFormat.Preference, err = ParseSourcePreference(*preference)
+	}
+	if err == nil {
+		outputFormat.Cover, err = ParseCoverMode(*cover)
+	}
+	var profile *FilenameProfile
+	if err == nil {
+		profile, err = ParseFilenameProfile(*filenames)
+	}
+	if err == nil {
+		outputFormat.Template, err = ParseTemplate(*template, profile)
+	}
+	if err == nil {
+		outputFormat.Conflict, err = ParseConflictPolicy(*onConflict)
+	}
+	var policy AcceptPolicy
+	if err == nil {
+		policy, err = ParseAcceptPolicy(*accept)
+	}
+	if err == nil && command != "" && *resume != "" {
+		err = fmt.Errorf("Sessions can only be resumed in the editor, %s and -resume can not be used together.", command)
+	}
+	if err == nil && batch && *resume != "" {
+		err = fmt.Errorf("Sessions can only be resumed in the editor, -batch and -resume can not be used together.")
+	}
+	if err == nil && syncMode && (*nLinks != 0 || *skip != 0) {
+		err = fmt.Errorf("Sync always mirrors the whole playlist, -n_links and -skip can not be used.")
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	source = flag.Arg(0)
 	output = flag.Arg(1)
 
-	links, err := getLinks(&client, source, *nLinks, *skip)
+	// Check arguments and tools before any video is fetched.
+	if *resume == "" {
+		err = validateSource(source)
+		if err == nil && syncMode && !strings.Contains(source, "youtube.com") {
+			err = fmt.Errorf("Sync needs a YouTube playlist as source.")
+		}
+		if err == nil && output == "" {
+			err = fmt.Errorf("Missing output folder.\nUsage: yt2mp3 [sync|plan|apply] [flags] {source} {output_folder}")
+		}
+	}
+	if err == nil && command != "plan" {
+		err = checkFFmpeg(backend, outputFormat)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var m model
+	// Quitting cancels downloads and conversions still in progress.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if command == "plan" {
+		links, album, err := getLinks(&client, source, *nLinks, *skip)
+		if err == nil {
+			err = runPlan(ctx, links, album, output)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *resume != "" {
+		session, err := LoadSession(*resume)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		source, output = session.Source, session.Output
+		sessionPath = *resume
+		m = session.restore()
+	} else {
+		sessionPath = filepath.Join(output, "session.json")
+	}
+
+	if err := prepareOutput(output); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *archivePath == "" {
+		*archivePath = filepath.Join(output, "archive.json")
+	}
+	archive, err := LoadArchive(*archivePath)
 	if err != nil {
-		log.Println(err)
-	}
-
-	fetchBar := progress.New(progress.WithScaledGradient("#FF7CCB", "#FDFF8C"))
-	downloadBar := progress.New(progress.WithScaledGradient("#FF7CCB", "#FDFF8C"))
-	editBar := progress.New(progress.WithScaledGradient("#FF7CCB", "#FDFF8C"))
-
-	m := model{
-		fetched:     make([]bool, len(links)),
-		downloaded:  make([]bool, len(links)),
-		links:       links,
-		songs:       make([]Song, 0, len(links)),
-		fetchBar:    fetchBar,
-		downloadBar: downloadBar,
-		editBar:     editBar,
-		timer:       timer.NewWithInterval(time.Second*10, time.Second),
-		inputs:      make([]textinput.Model, 2),
-	}
-
-	for i := range m.inputs {
-		input := textinput.New()
-		input.CursorStyle = cursorStyle
-		input.CharLimit = 64
-
-		switch i {
-		case 0:
-			input.Placeholder = "Title"
-			input.PromptStyle = focusedStyle
-			input.TextStyle = focusedStyle
-			input.Focus()
-		case 1:
-			input.Placeholder = "Artist"
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if command == "apply" {
+		if err := runApply(ctx, source, archive); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *resume == "" {
+		links, album, err := getLinks(&client, source, *nLinks, *skip)
+		if err != nil {
+			fmt.Println("Could not read links:", err)
+			os.Exit(1)
+		}
+		if len(links) == 0 {
+			fmt.Printf("No links found in %s.\n", source)
+			return
 		}
 
-		m.inputs[i] = input
+		archivedCount := 0
+		if syncMode {
+			if links, err = syncLinks(ctx, archive, links, output, *moveRemoved, outputFormat.Conflict); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if len(links) == 0 {
+				fmt.Println("Playlist is already in sync.")
+				return
+			}
+		} else {
+			links, archivedCount = archive.Filter(links)
+			if len(links) == 0 && archivedCount > 0 {
+				fmt.Printf("All %d videos were already downloaded.\n", archivedCount)
+				return
+			}
+		}
+
+		if err := checkDiskSpace(output, len(links), outputFormat); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if batch {
+			runBatch(ctx, links, album, archive, policy)
+			return
+		}
+
+		m = model{
+			fetched:    make([]bool, len(links)),
+			fetchQueue: make([]int, len(links)),
+			status:     make([]Status, len(links)),
+			links:      links,
+			album:      album,
+			archived:   archivedCount,
+			songs:      make([]Song, len(links)),
+		}
+		for i := range m.fetchQueue {
+			m.fetchQueue[i] = i
+		}
+	}
+
+	m.ctx = ctx
+	m.archive = archive
+	m.fetchWorkers = max(*fetchWorkers, 1)
+	m.fetchBar = progress.New(progress.WithScaledGradient("#FF7CCB", "#FDFF8C"))
+	m.downloadBar = progress.New(progress.WithScaledGradient("#FF7CCB", "#FDFF8C"))
+	m.editBar = progress.New(progress.WithScaledGradient("#FF7CCB", "#FDFF8C"))
+	m.timer = newCountdown()
+	m.inputs = newInputs()
+	m.workerCount = max(*downloadWorkers, 1)
+	m.transfers = make(map[int]transfer)
+	if m.view == int(edit) && m.editIndx < len(m.songs) {
+		m.loadInputs(m.editIndx)
 	}
 
-	p := tea.NewProgram(m, tea.WithAltScreen())
+	program = tea.NewProgram(m, tea.WithAltScreen())
 
-	if err := p.Start(); err != nil {
+	if err := program.Start(); err != nil {
 		fmt.Println("Could not start program:", err)
 	}
+
+	// Let running downloads stop and remove their partial files.
+	cancel()
+	downloads.wait()
 }
 
 type View int
@@ -102,43 +293,127 @@ const (
 	finish
 )
 
-type fetchMsg *Song
+// Status of a song once metadata is fetched.
+type Status int
+
+const (
+	pending Status = iota
+	queued
+	downloaded
+	downloadFailed
+	skipped
+)
+
+type fetchMsg struct {
+	index int
+	song  *Song
+	err   error
+}
 type errorMsg error
-type downloadMsg int
+type downloadMsg struct {
+	index  int
+	result downloadResult
+}
+type downloadErrorMsg struct {
+	index  int
+	result downloadResult
+}
+type downloadSkipMsg struct {
+	index  int
+	result downloadResult
+}
+type progressMsg struct {
+	index    int
+	received int64
+	total    int64
+}
 type saveMsg int
+type startMsg struct{}
+
+type transfer struct {
+	received int64
+	total    int64
+	started  time.Time
+	done     bool
+}
+
+// percent returns how much of the song was received, 0 while its size is
+// unknown.
+func (t transfer) percent() float64 {
+	if t.total <= 0 {
+		return 0
+	}
+
+	return min(float64(t.received)/float64(t.total)*100, 100)
+}
 
 type model struct {
+	// Cancelled on quit to stop running fetches and downloads.
+	ctx context.Context
+
 	failedFetch int
 	fetched     []bool
+	// Links that could not be fetched, kept for the run report.
+	fetchErrors []ReportEntry
 
-	skipCount  int
-	downloaded []bool
+	// Indexes of links waiting to be fetched.
+	fetchQueue   []int
+	fetchActive  int
+	fetchWorkers int
+
+	skipCount int
+	status    []Status
+	// Result of the last download of each song by its index.
+	results []downloadResult
 
 	links []string
 	songs []Song
+	album Album
+
+	// Videos downloaded in previous runs are left out of links.
+	archive  *Archive
+	archived int
 
 	fetchBar    progress.Model
 	downloadBar progress.Model
 	editBar     progress.Model
 	timer       timer.Model
 
-	inputs    []textinput.Model
-	focusIndx int
+	inputs     []textinput.Model
+	focusIndx  int
+	inputError error
 
 	fetchPercent    float64
 	downloadPercent float64
 	editPercent     float64
 
-	fetchCount    int
-	editIndx      int
+	fetchCount int
+	editIndx   int
+	// Songs sent back to the editor from the finish view.
+	editQueue     []int
 	downloadCount int
 	failedCount   int
 
+	// Indexes of confirmed songs waiting for a free download worker.
+	queue       []int
+	activeCount int
+	workerCount int
+
+	// Download progress of each started song by its index.
+	transfers map[int]transfer
+
+	showFailures bool
+	failureIndx  int
+
+	// Cursor and selection of failed and skipped songs in the finish view.
+	finishIndx int
+	selected   map[int]bool
+
 	err      error
 	view     int
 	quitting bool
 }
 
 func (m model) Init() tea.Cmd {
-	return tea.Batch(tea.EnterAltScreen, fetchCmd(m.links[0]))
+	return tea.Batch(tea.EnterAltScreen, func() tea.Msg { return startMsg{} })
 }
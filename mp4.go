@@ -0,0 +1,827 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// YouTube serves AAC audio as fragmented MP4 (DASH), where sample tables are
+// spread over moof boxes. Most players expect a regular MP4 with a single
+// sample table in moov, so the remuxer below collects every sample of the
+// audio track and writes them out again as a plain m4a file.
+
+var errNoAudioTrack = errors.New("No audio track found in mp4 file.")
+
+type mp4Sample struct {
+	offset   int64
+	size     uint32
+	duration uint32
+}
+
+type mp4Track struct {
+	id        uint32
+	timescale uint32
+	language  uint16
+	stsd      []byte // Raw sample description box.
+	samples   []mp4Sample
+
+	// Defaults from trex, used by fragments.
+	defaultDuration uint32
+	defaultSize     uint32
+}
+
+// mp4Metadata holds iTunes style metadata written into the ilst box.
+type mp4Metadata struct {
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Year        int
+	Genre       string
+	TrackNumber int
+	Comment     string
+	Cover       []byte
+}
+
+type mp4Box struct {
+	typ     string
+	raw     []byte // Whole box including the header.
+	payload []byte
+}
+
+// parseBoxes splits data into consecutive boxes.
+func parseBoxes(data []byte) ([]mp4Box, error) {
+	boxes := make([]mp4Box, 0)
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("Truncated mp4 box header.")
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("Truncated mp4 box header.")
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("Invalid size of mp4 box \"%s\".", data[4:8])
+		}
+
+		boxes = append(boxes, mp4Box{typ: string(data[4:8]), raw: data[:size], payload: data[header:size]})
+		data = data[size:]
+	}
+
+	return boxes, nil
+}
+
+// findBox returns the first box found by following the path of box types.
+func findBox(data []byte, path ...string) (mp4Box, bool) {
+	boxes, err := parseBoxes(data)
+	if err != nil {
+		return mp4Box{}, false
+	}
+
+	for _, box := range boxes {
+		if box.typ != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return box, true
+		}
+		return findBox(box.payload, path[1:]...)
+	}
+
+	return mp4Box{}, false
+}
+
+// fieldReader reads big endian fields and remembers the first error.
+type fieldReader struct {
+	data []byte
+	err  error
+}
+
+func (r *fieldReader) next(n int) []byte {
+	if r.err != nil || len(r.data) < n {
+		r.err = fmt.Errorf("Truncated mp4 box.")
+		return make([]byte, n)
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *fieldReader) u16() uint16 { return binary.BigEndian.Uint16(r.next(2)) }
+func (r *fieldReader) u32() uint32 { return binary.BigEndian.Uint32(r.next(4)) }
+func (r *fieldReader) u64() uint64 { return binary.BigEndian.Uint64(r.next(8)) }
+
+// fullBoxHeader reads version and flags of a full box.
+func (r *fieldReader) fullBoxHeader() (byte, uint32) {
+	v := r.u32()
+	return byte(v >> 24), v & 0xFFFFFF
+}
+
+// readMP4Track reads the sample layout of the first audio track, from either
+// a regular or a fragmented mp4 file.
+func readMP4Track(r io.ReaderAt, size int64) (*mp4Track, error) {
+	var track *mp4Track
+
+	for offset := int64(0); offset < size; {
+		header := make([]byte, 16)
+		n, err := r.ReadAt(header, offset)
+		if n < 8 {
+			return nil, fmt.Errorf("Could not read mp4 box header: %v", err)
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if n < 16 {
+				return nil, fmt.Errorf("Truncated mp4 box header.")
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:]))
+		}
+		if boxSize < 8 || offset+boxSize > size {
+			return nil, fmt.Errorf("Invalid size of mp4 box \"%s\".", header[4:8])
+		}
+
+		typ := string(header[4:8])
+		if typ == "moov" || typ == "moof" {
+			data := make([]byte, boxSize)
+			if _, err := r.ReadAt(data, offset); err != nil {
+				return nil, err
+			}
+
+			boxes, err := parseBoxes(data)
+			if err != nil {
+				return nil, err
+			}
+
+			if typ == "moov" {
+				track, err = parseMoov(boxes[0].payload)
+			} else if track != nil {
+				err = track.parseMoof(boxes[0].payload, offset)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		offset += boxSize
+	}
+
+	if track == nil {
+		return nil, errNoAudioTrack
+	}
+
+	return track, nil
+}
+
+func parseMoov(moov []byte) (*mp4Track, error) {
+	boxes, err := parseBoxes(moov)
+	if err != nil {
+		return nil, err
+	}
+
+	var track *mp4Track
+	for _, box := range boxes {
+		if box.typ != "trak" {
+			continue
+		}
+
+		hdlr, ok := findBox(box.payload, "mdia", "hdlr")
+		if !ok || len(hdlr.payload) < 12 || string(hdlr.payload[8:12]) != "soun" {
+			continue
+		}
+
+		track, err = parseTrak(box.payload)
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	if track == nil {
+		return nil, errNoAudioTrack
+	}
+
+	// Fragmented files keep per track defaults in mvex.
+	if mvex, ok := findBox(moov, "mvex"); ok {
+		boxes, err := parseBoxes(mvex.payload)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, box := range boxes {
+			if box.typ != "trex" {
+				continue
+			}
+
+			r := fieldReader{data: box.payload}
+			r.fullBoxHeader()
+			if r.u32() != track.id {
+				continue
+			}
+			r.u32() // default_sample_description_index
+			track.defaultDuration = r.u32()
+			track.defaultSize = r.u32()
+			if r.err != nil {
+				return nil, r.err
+			}
+		}
+	}
+
+	return track, nil
+}
+
+func parseTrak(trak []byte) (*mp4Track, error) {
+	track := mp4Track{}
+
+	tkhd, ok := findBox(trak, "tkhd")
+	if !ok {
+		return nil, fmt.Errorf("Missing tkhd box in mp4 file.")
+	}
+	r := fieldReader{data: tkhd.payload}
+	if version, _ := r.fullBoxHeader(); version == 1 {
+		r.next(16)
+	} else {
+		r.next(8)
+	}
+	track.id = r.u32()
+
+	mdhd, ok := findBox(trak, "mdia", "mdhd")
+	if !ok {
+		return nil, fmt.Errorf("Missing mdhd box in mp4 file.")
+	}
+	r = fieldReader{data: mdhd.payload}
+	if version, _ := r.fullBoxHeader(); version == 1 {
+		r.next(16)
+		track.timescale = r.u32()
+		r.u64()
+	} else {
+		r.next(8)
+		track.timescale = r.u32()
+		r.u32()
+	}
+	track.language = r.u16()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	stbl, ok := findBox(trak, "mdia", "minf", "stbl")
+	if !ok {
+		return nil, fmt.Errorf("Missing stbl box in mp4 file.")
+	}
+
+	stsd, ok := findBox(stbl.payload, "stsd")
+	if !ok {
+		return nil, fmt.Errorf("Missing stsd box in mp4 file.")
+	}
+	track.stsd = append([]byte(nil), stsd.raw...)
+
+	if err := track.parseStbl(stbl.payload); err != nil {
+		return nil, err
+	}
+
+	return &track, nil
+}
+
+// parseStbl reads samples of a regular mp4 file. Fragmented files have empty
+// sample tables, which leaves track.samples empty.
+func (t *mp4Track) parseStbl(stbl []byte) error {
+	stsz, ok := findBox(stbl, "stsz")
+	if !ok {
+		return nil
+	}
+
+	r := fieldReader{data: stsz.payload}
+	r.fullBoxHeader()
+	sampleSize := r.u32()
+	count := r.u32()
+	if r.err != nil {
+		return r.err
+	}
+	if sampleSize == 0 && int64(count) > int64(len(r.data)/4) {
+		return fmt.Errorf("Truncated mp4 box.")
+	}
+
+	samples := make([]mp4Sample, count)
+	for i := range samples {
+		samples[i].size = sampleSize
+		if sampleSize == 0 {
+			samples[i].size = r.u32()
+		}
+	}
+
+	if stts, ok := findBox(stbl, "stts"); ok {
+		r = fieldReader{data: stts.payload}
+		r.fullBoxHeader()
+		i := 0
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			count, delta := r.u32(), r.u32()
+			for ; count > 0 && i < len(samples); count-- {
+				samples[i].duration = delta
+				i++
+			}
+		}
+	}
+
+	chunks := make([]int64, 0)
+	if stco, ok := findBox(stbl, "stco"); ok {
+		r = fieldReader{data: stco.payload}
+		r.fullBoxHeader()
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			chunks = append(chunks, int64(r.u32()))
+		}
+	} else if co64, ok := findBox(stbl, "co64"); ok {
+		r = fieldReader{data: co64.payload}
+		r.fullBoxHeader()
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			chunks = append(chunks, int64(r.u64()))
+		}
+	}
+
+	type stscEntry struct{ firstChunk, samplesPerChunk uint32 }
+	entries := make([]stscEntry, 0)
+	if stsc, ok := findBox(stbl, "stsc"); ok {
+		r = fieldReader{data: stsc.payload}
+		r.fullBoxHeader()
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			entries = append(entries, stscEntry{r.u32(), r.u32()})
+			r.u32() // sample_description_index
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	// Walk chunks and place their samples one after another.
+	i, entry := 0, 0
+	for chunk := range chunks {
+		for entry+1 < len(entries) && uint32(chunk+1) >= entries[entry+1].firstChunk {
+			entry++
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		offset := chunks[chunk]
+		for n := entries[entry].samplesPerChunk; n > 0 && i < len(samples); n-- {
+			samples[i].offset = offset
+			offset += int64(samples[i].size)
+			i++
+		}
+	}
+
+	if i < len(samples) {
+		return fmt.Errorf("Sample table of mp4 file is incomplete.")
+	}
+
+	t.samples = samples
+	return nil
+}
+
+const (
+	tfhdBaseDataOffset       = 0x000001
+	tfhdSampleDescription    = 0x000002
+	tfhdDefaultDuration      = 0x000008
+	tfhdDefaultSize          = 0x000010
+	tfhdDefaultFlags         = 0x000020
+	tfhdDefaultBaseIsMoof    = 0x020000
+	trunDataOffset           = 0x000001
+	trunFirstSampleFlags     = 0x000004
+	trunSampleDuration       = 0x000100
+	trunSampleSize           = 0x000200
+	trunSampleFlags          = 0x000400
+	trunSampleCompositionOff = 0x000800
+)
+
+// parseMoof appends samples described by a movie fragment starting at the
+// given file offset.
+func (t *mp4Track) parseMoof(moof []byte, moofOffset int64) error {
+	boxes, err := parseBoxes(moof)
+	if err != nil {
+		return err
+	}
+
+	// Without an explicit base, the first track fragment starts at moof and
+	// every next one continues where the previous one ended.
+	dataEnd := moofOffset
+
+	for _, traf := range boxes {
+		if traf.typ != "traf" {
+			continue
+		}
+
+		tfhd, ok := findBox(traf.payload, "tfhd")
+		if !ok {
+			return fmt.Errorf("Missing tfhd box in mp4 fragment.")
+		}
+
+		r := fieldReader{data: tfhd.payload}
+		_, flags := r.fullBoxHeader()
+		trackID := r.u32()
+
+		base := dataEnd
+		duration, size := t.defaultDuration, t.defaultSize
+		if flags&tfhdBaseDataOffset != 0 {
+			base = int64(r.u64())
+		} else if flags&tfhdDefaultBaseIsMoof != 0 {
+			base = moofOffset
+		}
+		if flags&tfhdSampleDescription != 0 {
+			r.u32()
+		}
+		if flags&tfhdDefaultDuration != 0 {
+			duration = r.u32()
+		}
+		if flags&tfhdDefaultSize != 0 {
+			size = r.u32()
+		}
+		if flags&tfhdDefaultFlags != 0 {
+			r.u32()
+		}
+		if r.err != nil {
+			return r.err
+		}
+
+		children, err := parseBoxes(traf.payload)
+		if err != nil {
+			return err
+		}
+
+		offset := base
+		for _, trun := range children {
+			if trun.typ != "trun" {
+				continue
+			}
+
+			r := fieldReader{data: trun.payload}
+			_, flags := r.fullBoxHeader()
+			count := r.u32()
+			if flags&trunDataOffset != 0 {
+				offset = base + int64(int32(r.u32()))
+			}
+			if flags&trunFirstSampleFlags != 0 {
+				r.u32()
+			}
+
+			for ; count > 0 && r.err == nil; count-- {
+				sample := mp4Sample{offset: offset, size: size, duration: duration}
+				if flags&trunSampleDuration != 0 {
+					sample.duration = r.u32()
+				}
+				if flags&trunSampleSize != 0 {
+					sample.size = r.u32()
+				}
+				if flags&trunSampleFlags != 0 {
+					r.u32()
+				}
+				if flags&trunSampleCompositionOff != 0 {
+					r.u32()
+				}
+
+				offset += int64(sample.size)
+				if trackID == t.id {
+					t.samples = append(t.samples, sample)
+				}
+			}
+			if r.err != nil {
+				return r.err
+			}
+		}
+
+		dataEnd = offset
+	}
+
+	return nil
+}
+
+func (t *mp4Track) duration() uint64 {
+	var d uint64
+	for _, s := range t.samples {
+		d += uint64(s.duration)
+	}
+
+	return d
+}
+
+// writeM4A writes the track as a regular m4a file, copying sample data from
+// src into a single chunk placed right after the moov box.
+func writeM4A(w io.Writer, src io.ReaderAt, track *mp4Track, meta mp4Metadata) error {
+	if len(track.samples) == 0 {
+		return fmt.Errorf("No audio samples found in mp4 file.")
+	}
+
+	var dataSize int64
+	for _, s := range track.samples {
+		dataSize += int64(s.size)
+	}
+
+	ftyp := mp4BoxBytes("ftyp", []byte("M4A "), u32(0), []byte("M4A mp42isom"))
+
+	// The moov size does not depend on the chunk offset, so build it once to
+	// measure it and again with the final offset.
+	moov := buildMoov(track, meta, 0)
+	offset := int64(len(ftyp)+len(moov)) + 8
+	if offset+dataSize > math.MaxUint32 {
+		return fmt.Errorf("Audio track is too large for an m4a file.")
+	}
+	moov = buildMoov(track, meta, uint32(offset))
+
+	if _, err := w.Write(ftyp); err != nil {
+		return err
+	}
+	if _, err := w.Write(moov); err != nil {
+		return err
+	}
+	if _, err := w.Write(append(u32(uint32(dataSize+8)), "mdat"...)); err != nil {
+		return err
+	}
+
+	// Copy runs of adjacent samples with a single read.
+	for i := 0; i < len(track.samples); {
+		start := track.samples[i].offset
+		end := start + int64(track.samples[i].size)
+		for i++; i < len(track.samples) && track.samples[i].offset == end; i++ {
+			end += int64(track.samples[i].size)
+		}
+
+		if _, err := io.Copy(w, io.NewSectionReader(src, start, end-start)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeADTS writes every sample of the track as an ADTS frame, the raw AAC
+// stream format most players open as .aac.
+func writeADTS(w io.Writer, src io.ReaderAt, track *mp4Track) error {
+	if len(track.samples) == 0 {
+		return fmt.Errorf("No audio samples found in mp4 file.")
+	}
+
+	config, err := track.audioConfig()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0)
+	for _, s := range track.samples {
+		length := int(s.size) + 7
+		if length > 0x1FFF {
+			return fmt.Errorf("AAC frame is too large for ADTS.")
+		}
+
+		// Header without CRC: MPEG-4, profile, sampling frequency index,
+		// channel configuration, frame length and a variable buffer fullness.
+		header := []byte{
+			0xFF,
+			0xF1,
+			(config.objectType-1)<<6 | config.frequencyIndex<<2 | config.channels>>2,
+			config.channels<<6 | byte(length>>11),
+			byte(length >> 3),
+			byte(length<<5) | 0x1F,
+			0xFC,
+		}
+
+		buf = append(buf[:0], header...)
+		buf = append(buf, make([]byte, s.size)...)
+		if _, err := src.ReadAt(buf[7:], s.offset); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// aacConfig holds the fields of an AAC AudioSpecificConfig ADTS headers need.
+type aacConfig struct {
+	objectType     byte
+	frequencyIndex byte
+	channels       byte
+}
+
+// audioConfig reads the AudioSpecificConfig from the esds box of the track's
+// mp4a sample entry.
+func (t *mp4Track) audioConfig() (aacConfig, error) {
+	errConfig := fmt.Errorf("Could not read AAC configuration of mp4 file.")
+
+	// stsd: full box header and entry count, then the sample entry.
+	if len(t.stsd) < 16 {
+		return aacConfig{}, errConfig
+	}
+	entries, err := parseBoxes(t.stsd[16:])
+	if err != nil || len(entries) == 0 || entries[0].typ != "mp4a" || len(entries[0].payload) < 28 {
+		return aacConfig{}, errConfig
+	}
+
+	// Audio sample entry fields take 28 bytes before the child boxes.
+	esds, ok := findBox(entries[0].payload[28:], "esds")
+	if !ok || len(esds.payload) < 4 {
+		return aacConfig{}, errConfig
+	}
+
+	// Walk ES_Descriptor (3), DecoderConfigDescriptor (4) down to
+	// DecoderSpecificInfo (5).
+	data := esds.payload[4:]
+	for len(data) > 0 {
+		tag := data[0]
+		size, n := 0, 1
+		for ; n < len(data) && n <= 4; n++ {
+			size = size<<7 | int(data[n]&0x7F)
+			if data[n]&0x80 == 0 {
+				n++
+				break
+			}
+		}
+		data = data[n:]
+		if size > len(data) {
+			return aacConfig{}, errConfig
+		}
+
+		switch tag {
+		case 3:
+			if len(data) < 3 {
+				return aacConfig{}, errConfig
+			}
+			flags := data[2]
+			skip := 3
+			if flags&0x80 != 0 {
+				skip += 2
+			}
+			if flags&0x40 != 0 && len(data) > skip {
+				skip += 1 + int(data[skip])
+			}
+			if flags&0x20 != 0 {
+				skip += 2
+			}
+			if skip > len(data) {
+				return aacConfig{}, errConfig
+			}
+			data = data[skip:]
+		case 4:
+			if len(data) < 13 {
+				return aacConfig{}, errConfig
+			}
+			data = data[13:]
+		case 5:
+			if size < 2 {
+				return aacConfig{}, errConfig
+			}
+			config := aacConfig{
+				objectType:     data[0] >> 3,
+				frequencyIndex: (data[0]&0x07)<<1 | data[1]>>7,
+				channels:       (data[1] >> 3) & 0x0F,
+			}
+			// ADTS can only describe AAC Main, LC, SSR and LTP with a
+			// standard sampling frequency.
+			if config.objectType < 1 || config.objectType > 4 || config.frequencyIndex > 12 {
+				return aacConfig{}, fmt.Errorf("AAC stream can not be stored as ADTS.")
+			}
+			return config, nil
+		default:
+			data = data[size:]
+		}
+	}
+
+	return aacConfig{}, errConfig
+}
+
+func buildMoov(track *mp4Track, meta mp4Metadata, chunkOffset uint32) []byte {
+	duration := uint32(min(track.duration(), math.MaxUint32))
+	matrix := concat(u32(0x00010000), u32(0), u32(0), u32(0), u32(0x00010000), u32(0), u32(0), u32(0), u32(0x40000000))
+
+	mvhd := fullBoxBytes("mvhd", 0, 0,
+		u32(0), u32(0), u32(track.timescale), u32(duration),
+		u32(0x00010000), u16(0x0100), make([]byte, 10), matrix, make([]byte, 24), u32(2))
+
+	tkhd := fullBoxBytes("tkhd", 0, 3,
+		u32(0), u32(0), u32(1), u32(0), u32(duration),
+		make([]byte, 8), u16(0), u16(0), u16(0x0100), u16(0), matrix, u32(0), u32(0))
+
+	mdhd := fullBoxBytes("mdhd", 0, 0,
+		u32(0), u32(0), u32(track.timescale), u32(duration), u16(track.language), u16(0))
+
+	hdlr := fullBoxBytes("hdlr", 0, 0, u32(0), []byte("soun"), make([]byte, 12), []byte("SoundHandler\x00"))
+
+	smhd := fullBoxBytes("smhd", 0, 0, u16(0), u16(0))
+	dinf := mp4BoxBytes("dinf", fullBoxBytes("dref", 0, 0, u32(1), fullBoxBytes("url ", 0, 1)))
+
+	// Run length encoded sample durations.
+	stts := make([]byte, 0)
+	entries := uint32(0)
+	for i := 0; i < len(track.samples); {
+		j := i + 1
+		for j < len(track.samples) && track.samples[j].duration == track.samples[i].duration {
+			j++
+		}
+		stts = append(stts, concat(u32(uint32(j-i)), u32(track.samples[i].duration))...)
+		entries++
+		i = j
+	}
+
+	sizes := make([]byte, 0, 4*len(track.samples))
+	for _, s := range track.samples {
+		sizes = append(sizes, u32(s.size)...)
+	}
+
+	stbl := mp4BoxBytes("stbl",
+		track.stsd,
+		fullBoxBytes("stts", 0, 0, u32(entries), stts),
+		fullBoxBytes("stsc", 0, 0, u32(1), u32(1), u32(uint32(len(track.samples))), u32(1)),
+		fullBoxBytes("stsz", 0, 0, u32(0), u32(uint32(len(track.samples))), sizes),
+		fullBoxBytes("stco", 0, 0, u32(1), u32(chunkOffset)))
+
+	trak := mp4BoxBytes("trak", tkhd, mp4BoxBytes("mdia", mdhd, hdlr, mp4BoxBytes("minf", smhd, dinf, stbl)))
+
+	return mp4BoxBytes("moov", mvhd, trak, buildUdta(meta))
+}
+
+// buildUdta builds the iTunes metadata boxes.
+func buildUdta(meta mp4Metadata) []byte {
+	const (
+		dataImplicit = 0
+		dataUTF8     = 1
+		dataJPEG     = 13
+		dataPNG      = 14
+	)
+
+	item := func(typ string, kind uint32, value []byte) []byte {
+		return mp4BoxBytes(typ, mp4BoxBytes("data", u32(kind), u32(0), value))
+	}
+
+	ilst := make([]byte, 0)
+	year := ""
+	if meta.Year > 0 {
+		year = strconv.Itoa(meta.Year)
+	}
+
+	texts := [][2]string{
+		{"\xa9nam", meta.Title},
+		{"\xa9ART", meta.Artist},
+		{"\xa9alb", meta.Album},
+		{"aART", meta.AlbumArtist},
+		{"\xa9day", year},
+		{"\xa9gen", meta.Genre},
+		{"\xa9cmt", meta.Comment},
+	}
+	for _, text := range texts {
+		if text[1] != "" {
+			ilst = append(ilst, item(text[0], dataUTF8, []byte(text[1]))...)
+		}
+	}
+	if meta.TrackNumber > 0 {
+		// Reserved, track number, total tracks (unknown) and reserved.
+		trkn := concat(u16(0), u16(uint16(meta.TrackNumber)), u16(0), u16(0))
+		ilst = append(ilst, item("trkn", dataImplicit, trkn)...)
+	}
+	if len(meta.Cover) > 0 {
+		kind := uint32(dataJPEG)
+		if len(meta.Cover) > 4 && string(meta.Cover[1:4]) == "PNG" {
+			kind = dataPNG
+		}
+		ilst = append(ilst, item("covr", kind, meta.Cover)...)
+	}
+
+	hdlr := fullBoxBytes("hdlr", 0, 0, u32(0), []byte("mdirappl"), make([]byte, 8), []byte{0})
+	return mp4BoxBytes("udta", fullBoxBytes("meta", 0, 0, hdlr, mp4BoxBytes("ilst", ilst)))
+}
+
+func mp4BoxBytes(typ string, parts ...[]byte) []byte {
+	payload := concat(parts...)
+	return concat(u32(uint32(len(payload)+8)), []byte(typ), payload)
+}
+
+func fullBoxBytes(typ string, version byte, flags uint32, parts ...[]byte) []byte {
+	return mp4BoxBytes(typ, append([][]byte{u32(uint32(version)<<24 | flags)}, parts...)...)
+}
+
+func concat(parts ...[]byte) []byte {
+	b := make([]byte, 0)
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+
+	return b
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
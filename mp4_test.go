@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fragmentedMP4 builds a minimal DASH style audio file with one fragment per
+// entry in fragments.
+func fragmentedMP4(fragments ...[][]byte) []byte {
+	stsd := fullBoxBytes("stsd", 0, 0, u32(1), mp4BoxBytes("mp4a", make([]byte, 28)))
+	stbl := mp4BoxBytes("stbl", stsd,
+		fullBoxBytes("stts", 0, 0, u32(0)),
+		fullBoxBytes("stsc", 0, 0, u32(0)),
+		fullBoxBytes("stsz", 0, 0, u32(0), u32(0)),
+		fullBoxBytes("stco", 0, 0, u32(0)))
+
+	trak := mp4BoxBytes("trak",
+		fullBoxBytes("tkhd", 0, 3, u32(0), u32(0), u32(7), make([]byte, 68)),
+		mp4BoxBytes("mdia",
+			fullBoxBytes("mdhd", 0, 0, u32(0), u32(0), u32(44100), u32(0), u16(0x55C4), u16(0)),
+			fullBoxBytes("hdlr", 0, 0, u32(0), []byte("soun"), make([]byte, 13)),
+			mp4BoxBytes("minf", stbl)))
+
+	mvex := mp4BoxBytes("mvex", fullBoxBytes("trex", 0, 0, u32(7), u32(1), u32(1024), u32(0), u32(0)))
+	file := concat(mp4BoxBytes("ftyp", []byte("dash"), u32(0)), mp4BoxBytes("moov", trak, mvex))
+
+	for _, samples := range fragments {
+		sizes := make([]byte, 0)
+		data := make([]byte, 0)
+		for _, s := range samples {
+			sizes = append(sizes, u32(uint32(len(s)))...)
+			data = append(data, s...)
+		}
+
+		// The trun data offset points right after the moof header of mdat.
+		traf := func(offset uint32) []byte {
+			return mp4BoxBytes("traf",
+				fullBoxBytes("tfhd", 0, tfhdDefaultBaseIsMoof, u32(7)),
+				fullBoxBytes("trun", 0, trunDataOffset|trunSampleSize, u32(uint32(len(samples))), u32(offset), sizes))
+		}
+		moof := mp4BoxBytes("moof", fullBoxBytes("mfhd", 0, 0, u32(1)), traf(0))
+		moof = mp4BoxBytes("moof", fullBoxBytes("mfhd", 0, 0, u32(1)), traf(uint32(len(moof)+8)))
+
+		file = concat(file, moof, mp4BoxBytes("mdat", data))
+	}
+
+	return file
+}
+
+func TestRemuxFragmentedMP4(t *testing.T) {
+	samples := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	src := fragmentedMP4(samples[:2], samples[2:])
+
+	track, err := readMP4Track(bytes.NewReader(src), int64(len(src)))
+	require.NoError(t, err)
+	require.Len(t, track.samples, 3)
+	require.Equal(t, uint64(3*1024), track.duration())
+
+	var out bytes.Buffer
+	meta := mp4Metadata{Title: "Kings & Queens", Artist: "Ava Max"}
+	require.NoError(t, writeM4A(&out, bytes.NewReader(src), track, meta))
+
+	// Read the remuxed file back through its regular sample table.
+	remuxed, err := readMP4Track(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NoError(t, err)
+	require.Len(t, remuxed.samples, 3)
+	require.Equal(t, uint32(44100), remuxed.timescale)
+
+	for i, s := range remuxed.samples {
+		require.Equal(t, samples[i], out.Bytes()[s.offset:s.offset+int64(s.size)])
+		require.Equal(t, uint32(1024), s.duration)
+	}
+
+	udta, ok := findBox(out.Bytes(), "moov", "udta")
+	require.True(t, ok)
+	require.Contains(t, string(udta.payload), "\xa9nam")
+	require.Contains(t, string(udta.payload), "Kings & Queens")
+}
+
+func TestWriteADTS(t *testing.T) {
+	// AAC LC, 44100 Hz, stereo.
+	decoderConfig := concat([]byte{0x40, 0x15}, make([]byte, 11), []byte{5, 2, 0x12, 0x10})
+	esDescriptor := concat([]byte{0, 1, 0}, []byte{4, byte(len(decoderConfig))}, decoderConfig)
+	esds := fullBoxBytes("esds", 0, 0, []byte{3, byte(len(esDescriptor))}, esDescriptor)
+	stsd := fullBoxBytes("stsd", 0, 0, u32(1), mp4BoxBytes("mp4a", make([]byte, 28), esds))
+
+	src := []byte("firstsecond")
+	track := &mp4Track{stsd: stsd, samples: []mp4Sample{{offset: 0, size: 5}, {offset: 5, size: 6}}}
+
+	var out bytes.Buffer
+	require.NoError(t, writeADTS(&out, bytes.NewReader(src), track))
+	require.Equal(t, concat(
+		[]byte{0xFF, 0xF1, 0x50, 0x80, 0x01, 0x9F, 0xFC}, []byte("first"),
+		[]byte{0xFF, 0xF1, 0x50, 0x80, 0x01, 0xBF, 0xFC}, []byte("second"),
+	), out.Bytes())
+
+	// Sample entries without an esds box can not be described in ADTS.
+	track.stsd = fullBoxBytes("stsd", 0, 0, u32(1), mp4BoxBytes("mp4a", make([]byte, 28)))
+	require.Error(t, writeADTS(&out, bytes.NewReader(src), track))
+}
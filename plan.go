@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PlanEntry is one song of a plan file. Metadata fields can be edited
+// before the plan is applied, the rest only helps to review them.
+type PlanEntry struct {
+	ID            string `json:"id" yaml:"id"`
+	OriginalTitle string `json:"original_title" yaml:"original_title"`
+	Author        string `json:"author" yaml:"author"`
+	Reliable      string `json:"reliable" yaml:"reliable"`
+
+	Title       string `json:"title" yaml:"title"`
+	Artist      string `json:"artist" yaml:"artist"`
+	Album       string `json:"album,omitempty" yaml:"album,omitempty"`
+	AlbumArtist string `json:"album_artist,omitempty" yaml:"album_artist,omitempty"`
+	Year        int    `json:"year,omitempty" yaml:"year,omitempty"`
+	Genre       string `json:"genre,omitempty" yaml:"genre,omitempty"`
+	TrackNumber int    `json:"track,omitempty" yaml:"track,omitempty"`
+}
+
+var planColumns = []string{"id", "original_title", "author", "reliable", "title", "artist", "album", "album_artist", "year", "genre", "track"}
+
+func newPlanEntry(s *Song) PlanEntry {
+	return PlanEntry{
+		ID:            s.Video.ID,
+		OriginalTitle: s.Video.Title,
+		Author:        s.Video.Author,
+		Reliable:      s.Reliable.String(),
+		Title:         s.Title,
+		Artist:        s.Artist,
+		Album:         s.Album,
+		AlbumArtist:   s.AlbumArtist,
+		Year:          s.Year,
+		Genre:         s.Genre,
+		TrackNumber:   s.TrackNumber,
+	}
+}
+
+// apply sets the edited metadata on a song.
+func (e PlanEntry) apply(s *Song) {
+	s.Title = e.Title
+	s.Artist = e.Artist
+	s.Album = e.Album
+	s.AlbumArtist = e.AlbumArtist
+	s.Year = e.Year
+	s.Genre = e.Genre
+	s.TrackNumber = e.TrackNumber
+}
+
+func (e PlanEntry) URL() string {
+	return fmt.Sprintf("https://www.youtube.com/watch?v=%s", e.ID)
+}
+
+// planFormat returns the format of a plan file from its extension.
+func planFormat(path string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv", ".json":
+		return ext[1:], nil
+	case ".yaml", ".yml":
+		return "yaml", nil
+	default:
+		return "", fmt.Errorf("Unknown plan file format \"%s\", expected .csv, .json or .yaml.", ext)
+	}
+}
+
+func WritePlan(path string, entries []PlanEntry) error {
+	format, err := planFormat(path)
+	if err != nil {
+		return err
+	}
+
+	var b []byte
+	switch format {
+	case "json":
+		b, err = json.MarshalIndent(entries, "", "  ")
+	case "yaml":
+		b, err = yaml.Marshal(entries)
+	default:
+		b, err = marshalPlanCSV(entries)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not encode plan file: %v", err)
+	}
+
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("Could not write plan file: %v", err)
+	}
+
+	return nil
+}
+
+func ReadPlan(path string) ([]PlanEntry, error) {
+	format, err := planFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read plan file: %v", err)
+	}
+
+	entries := make([]PlanEntry, 0)
+	switch format {
+	case "json":
+		err = json.Unmarshal(b, &entries)
+	case "yaml":
+		err = yaml.Unmarshal(b, &entries)
+	default:
+		entries, err = unmarshalPlanCSV(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse plan file %s: %v", path, err)
+	}
+
+	// Rows count from 1, below the header row in csv files.
+	invalid := make([]string, 0)
+	for i, entry := range entries {
+		row := i + 1
+		if format == "csv" {
+			row += 1
+		}
+
+		if entry.ID == "" {
+			invalid = append(invalid, fmt.Sprintf("row %d: Missing video id.", row))
+		} else if err := entry.validate(); err != nil {
+			invalid = append(invalid, fmt.Sprintf("row %d: %v", row, err))
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("Plan file %s has invalid rows:\n%s", path, strings.Join(invalid, "\n"))
+	}
+
+	return entries, nil
+}
+
+// validate checks the metadata of the entry with the rules of the editor.
+func (e PlanEntry) validate() error {
+	var song Song
+	e.apply(&song)
+
+	for _, f := range fields {
+		if f.validate == nil {
+			continue
+		}
+		if err := f.validate(strings.TrimSpace(f.get(&song))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func marshalPlanCSV(entries []PlanEntry) ([]byte, error) {
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Write(planColumns)
+
+	for _, e := range entries {
+		w.Write([]string{
+			e.ID, e.OriginalTitle, e.Author, e.Reliable, e.Title, e.Artist,
+			e.Album, e.AlbumArtist, formatNumber(e.Year), e.Genre, formatNumber(e.TrackNumber),
+		})
+	}
+
+	w.Flush()
+	return []byte(sb.String()), w.Error()
+}
+
+// unmarshalPlanCSV reads rows by the header, so columns can be reordered or
+// left out in a spreadsheet.
+func unmarshalPlanCSV(b []byte) ([]PlanEntry, error) {
+	r := csv.NewReader(strings.NewReader(string(b)))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("missing header")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+
+	entries := make([]PlanEntry, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		// Numbers are checked as written, where 0 is not taken for an empty cell.
+		for _, column := range []string{"year", "track"} {
+			if err := fieldValidator(column)(get(column)); err != nil {
+				return nil, fmt.Errorf("row %d: %v", n+2, err)
+			}
+		}
+
+		var year, track int
+		if year, err = parsePlanNumber(get("year")); err != nil {
+			return nil, fmt.Errorf("row %d: year %v", n+2, err)
+		}
+		if track, err = parsePlanNumber(get("track")); err != nil {
+			return nil, fmt.Errorf("row %d: track %v", n+2, err)
+		}
+
+		entries = append(entries, PlanEntry{
+			ID:            get("id"),
+			OriginalTitle: get("original_title"),
+			Author:        get("author"),
+			Reliable:      get("reliable"),
+			Title:         get("title"),
+			Artist:        get("artist"),
+			Album:         get("album"),
+			AlbumArtist:   get("album_artist"),
+			Year:          year,
+			Genre:         get("genre"),
+			TrackNumber:   track,
+		})
+	}
+
+	return entries, nil
+}
+
+func parsePlanNumber(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+// runPlan fetches metadata of links and writes it into a plan file for
+// editing outside of the tool.
+func runPlan(ctx context.Context, links []string, album Album, path string) error {
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	songs, _ := fetchAll(ctx, logger, links)
+
+	entries := make([]PlanEntry, 0, len(songs))
+	failed := make([]string, 0)
+	for i, song := range songs {
+		if song == nil {
+			failed = append(failed, links[i])
+			continue
+		}
+
+		song.Album = album.Title
+		song.TrackNumber = album.Tracks[links[i]]
+		entries = append(entries, newPlanEntry(song))
+	}
+
+	if err := WritePlan(path, entries); err != nil {
+		return err
+	}
+
+	logger.Printf("Wrote %d songs to %s, %d videos could not be fetched.", len(entries), path, len(failed))
+	for _, link := range failed {
+		logger.Println("Failed:", link)
+	}
+
+	return nil
+}
+
+// runApply downloads songs of a plan file with the metadata written in it.
+// Songs removed from the file are not downloaded.
+func runApply(ctx context.Context, path string, archive *Archive) error {
+	entries, err := ReadPlan(path)
+	if err != nil {
+		return err
+	}
+
+	links := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		links = append(links, entry.URL())
+	}
+	links, archivedCount := archive.Filter(links)
+
+	logger := log.New(os.Stdout, "", log.LstdFlags)
+	if archivedCount > 0 {
+		logger.Printf("Skipping %d songs that were already downloaded.", archivedCount)
+	}
+	if err := checkDiskSpace(output, len(links), outputFormat); err != nil {
+		return err
+	}
+
+	byID := make(map[string]PlanEntry, len(entries))
+	for _, entry := range entries {
+		byID[entry.ID] = entry
+	}
+
+	report := make([]ReportEntry, 0, len(links))
+	fetchedLinks := make([]string, 0, len(links))
+	songs := make([]*Song, 0, len(links))
+	fetched, errs := fetchAll(ctx, logger, links)
+	for i, song := range fetched {
+		if song == nil {
+			report = append(report, fetchReport(links[i], errs[i]))
+			continue
+		}
+
+		byID[song.Video.ID].apply(song)
+		fetchedLinks = append(fetchedLinks, links[i])
+		songs = append(songs, song)
+	}
+
+	report = append(report, downloadAll(ctx, logger, fetchedLinks, songs, archive)...)
+	failed := failedLinks(report)
+
+	if err := writeLinks("failed.txt", failed); err != nil {
+		logger.Println(err)
+	}
+	if err := WriteReport(report); err != nil {
+		logger.Println(err)
+	}
+
+	logger.Printf("%d downloaded • %d failed", countStatus(report, Downloaded), len(failed))
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanRoundTrip(t *testing.T) {
+	entries := []PlanEntry{
+		{ID: "dQw4w9WgXcQ", OriginalTitle: "Rick Astley - Never Gonna Give You Up (Official Video)", Author: "Rick Astley", Reliable: "yes", Title: "Never Gonna Give You Up", Artist: "Rick Astley", Year: 2009, TrackNumber: 1},
+		{ID: "y6120QOlsfU", OriginalTitle: "Darude - Sandstorm, \"Official\"", Author: "Darude", Reliable: "maybe", Title: "Sandstorm", Artist: "Darude", Album: "Before the Storm", Genre: "Trance"},
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"plan.csv", "plan.json", "plan.yaml"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, WritePlan(path, entries))
+
+		read, err := ReadPlan(path)
+		require.NoError(t, err, name)
+		require.Equal(t, entries, read, name)
+	}
+
+	require.Error(t, WritePlan(filepath.Join(dir, "plan.txt"), entries))
+}
+
+func TestReadPlanCSV(t *testing.T) {
+	// Columns can be reordered and left out.
+	path := filepath.Join(t.TempDir(), "plan.csv")
+	require.NoError(t, os.WriteFile(path, []byte("artist,title,id,year\nDarude,Sandstorm,y6120QOlsfU,1999\n"), 0644))
+
+	entries, err := ReadPlan(path)
+	require.NoError(t, err)
+	require.Equal(t, []PlanEntry{{ID: "y6120QOlsfU", Title: "Sandstorm", Artist: "Darude", Year: 1999}}, entries)
+
+	require.NoError(t, os.WriteFile(path, []byte("id,year\ny6120QOlsfU,soon\n"), 0644))
+	_, err = ReadPlan(path)
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("title\nSandstorm\n"), 0644))
+	_, err = ReadPlan(path)
+	require.Error(t, err)
+
+	// Rows are checked like the editor checks its fields.
+	require.NoError(t, os.WriteFile(path, []byte("id,title,artist,track\ny6120QOlsfU,Sandstorm,Darude,1\ndQw4w9WgXcQ,,Rick Astley,2\n"), 0644))
+	_, err = ReadPlan(path)
+	require.ErrorContains(t, err, "row 3: Title can not be empty.")
+
+	require.NoError(t, os.WriteFile(path, []byte("id,title,artist,year\ny6120QOlsfU,Sandstorm,Darude,0\n"), 0644))
+	_, err = ReadPlan(path)
+	require.ErrorContains(t, err, "row 2: Year must be a number between 1 and 9999.")
+
+	path = filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"id": "y6120QOlsfU", "title": "Sandstorm", "artist": "Darude", "track": 70000}]`), 0644))
+	_, err = ReadPlan(path)
+	require.ErrorContains(t, err, "row 1: Track must be a number between 1 and 65535.")
+}
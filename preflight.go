@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minFFmpegVersion is the oldest ffmpeg release the encoder arguments were
+// tested with.
+const minFFmpegVersion = 4
+
+// averageSongLength is used to estimate disk space before song durations
+// are known.
+const averageSongLength = 4 * time.Minute
+
+// validateSource checks that source is a YouTube playlist link or a readable
+// file with links.
+func validateSource(source string) error {
+	if source == "" {
+		return fmt.Errorf("Missing source, expected a YouTube playlist or a file with links.")
+	}
+
+	if strings.Contains(source, "youtube.com") {
+		u, err := url.Parse(source)
+		if err != nil || u.Query().Get("list") == "" {
+			return fmt.Errorf("Source %s is not a YouTube playlist link, it has no list parameter.", source)
+		}
+		return nil
+	}
+
+	info, err := os.Stat(source)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("Source %s is neither a YouTube playlist link nor an existing file.", source)
+	} else if err != nil {
+		return fmt.Errorf("Could not read source file: %v", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("Source %s is a folder, expected a file with links.", source)
+	}
+
+	return nil
+}
+
+// prepareOutput creates the output folder when it is missing and checks
+// that files can be written into it.
+func prepareOutput(output string) error {
+	if output == "" {
+		return fmt.Errorf("Missing output folder.")
+	}
+
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return fmt.Errorf("Could not create output folder: %v", err)
+	}
+
+	file, err := os.CreateTemp(output, ".yt2mp3-check-*")
+	if err != nil {
+		return fmt.Errorf("Could not write into output folder %s: %v", output, err)
+	}
+	file.Close()
+
+	return os.Remove(file.Name())
+}
+
+// checkFFmpeg checks that ffmpeg is recent enough and has the encoders the
+// output format needs. The built-in backend does not use ffmpeg.
+func checkFFmpeg(backend Backend, format OutputFormat) error {
+	if backend.Name() != "ffmpeg" {
+		return nil
+	}
+
+	out, err := exec.Command("ffmpeg", "-hide_banner", "-version").Output()
+	if err != nil {
+		return fmt.Errorf("Could not run ffmpeg: %v", err)
+	}
+
+	version, major := parseFFmpegVersion(out)
+	if major > 0 && major < minFFmpegVersion {
+		return fmt.Errorf("ffmpeg %s is too old, version %d or newer is needed.", version, minFFmpegVersion)
+	}
+
+	out, err = exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return fmt.Errorf("Could not list ffmpeg encoders: %v", err)
+	}
+
+	available := parseFFmpegEncoders(out)
+	for _, codec := range ffmpegCodecs(format) {
+		if !available[codec] {
+			return fmt.Errorf("ffmpeg %s was built without the %s encoder needed for %s files.", version, codec, format.Extension)
+		}
+	}
+
+	return nil
+}
+
+var ffmpegVersionRegex = regexp.MustCompile(`^ffmpeg version n?(\S+)`)
+
+// parseFFmpegVersion returns the version from ffmpeg -version output and its
+// major number, which is 0 for development builds such as N-112345-g1234.
+func parseFFmpegVersion(out []byte) (string, int) {
+	line, _, _ := bytes.Cut(out, []byte("\n"))
+	match := ffmpegVersionRegex.FindSubmatch(line)
+	if match == nil {
+		return "unknown", 0
+	}
+
+	version := string(match[1])
+	majorText, _, _ := strings.Cut(version, ".")
+	major, _ := strconv.Atoi(majorText)
+
+	return version, major
+}
+
+// parseFFmpegEncoders returns names of encoders listed by ffmpeg -encoders.
+func parseFFmpegEncoders(out []byte) map[string]bool {
+	encoders := make(map[string]bool)
+	listed := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Encoders follow the legend, which ends with a " ------" line.
+		if len(fields) == 1 && strings.HasPrefix(fields[0], "---") {
+			listed = true
+			continue
+		}
+		if listed && len(fields) >= 2 {
+			encoders[fields[1]] = true
+		}
+	}
+
+	return encoders
+}
+
+// ffmpegCodecs returns ffmpeg encoders the output format may use.
+func ffmpegCodecs(format OutputFormat) []string {
+	encoder := format.Encoder
+	switch e := encoder.(type) {
+	case mp4Remuxer:
+		encoder = e.fallback
+	case adtsExtractor:
+		encoder = e.fallback
+	}
+
+	if e, ok := encoder.(ffmpegEncoder); ok {
+		return []string{e.codec}
+	}
+
+	return nil
+}
+
+// EstimateSize returns the expected size of a song of the given length,
+// including the source stream kept while it is converted.
+func (f OutputFormat) EstimateSize(length time.Duration) int64 {
+	bitrate := 192000
+	if f.Quality.Bitrate > 0 {
+		bitrate = f.Quality.Bitrate
+	} else if f.Extension == "flac" {
+		bitrate = 900000
+	}
+
+	// YouTube audio streams are at most about 160 kbps.
+	return int64(length.Seconds() * float64(bitrate+160000) / 8)
+}
+
+// checkDiskSpace checks that the output folder has room for the given
+// number of songs. It passes when free space can not be read.
+func checkDiskSpace(output string, songs int, format OutputFormat) error {
+	free, ok := freeSpace(output)
+	if !ok {
+		return nil
+	}
+
+	needed := int64(songs) * format.EstimateSize(averageSongLength)
+	if needed > free {
+		return fmt.Errorf("Output folder has %s free, but %d songs need about %s.", formatBytes(free), songs, formatBytes(needed))
+	}
+
+	return nil
+}
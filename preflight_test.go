@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFFmpegVersion(t *testing.T) {
+	version, major := parseFFmpegVersion([]byte("ffmpeg version 6.1.1-3ubuntu5 Copyright (c) 2000-2023 the FFmpeg developers\nbuilt with gcc 13"))
+	require.Equal(t, "6.1.1-3ubuntu5", version)
+	require.Equal(t, 6, major)
+
+	version, major = parseFFmpegVersion([]byte("ffmpeg version n7.0 Copyright (c) 2000-2024 the FFmpeg developers"))
+	require.Equal(t, "7.0", version)
+	require.Equal(t, 7, major)
+
+	// Development builds have no release number.
+	_, major = parseFFmpegVersion([]byte("ffmpeg version N-112345-g1234abcd Copyright"))
+	require.Equal(t, 0, major)
+}
+
+func TestParseFFmpegEncoders(t *testing.T) {
+	out := []byte(`Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC
+ A....D aac                  AAC (Advanced Audio Coding)
+ A....D libmp3lame           libmp3lame MP3 (MPEG audio layer 3) (codec mp3)
+`)
+
+	encoders := parseFFmpegEncoders(out)
+	require.True(t, encoders["aac"])
+	require.True(t, encoders["libmp3lame"])
+	require.False(t, encoders["libopus"])
+	require.False(t, encoders["="])
+
+	mp3, err := ffmpegBackend{}.OutputFormat("mp3")
+	require.NoError(t, err)
+	require.Equal(t, []string{"libmp3lame"}, ffmpegCodecs(mp3))
+
+	m4a, err := ffmpegBackend{}.OutputFormat("m4a")
+	require.NoError(t, err)
+	require.Equal(t, []string{"aac"}, ffmpegCodecs(m4a))
+}
+
+func TestPreflight(t *testing.T) {
+	dir := t.TempDir()
+	links := filepath.Join(dir, "links.txt")
+	require.NoError(t, os.WriteFile(links, []byte("https://youtu.be/a\nhttps://youtu.be/b\n\nhttps://youtu.be/c\n"), 0644))
+
+	require.NoError(t, validateSource(links))
+	require.NoError(t, validateSource("https://www.youtube.com/playlist?list=PL4fGSI1pDJn6jXS_Tv_N9B8Z0HTRVJE0m"))
+	require.Error(t, validateSource("https://www.youtube.com/watch?v=dQw4w9WgXcQ"))
+	require.Error(t, validateSource(filepath.Join(dir, "missing.txt")))
+	require.Error(t, validateSource(dir))
+	require.Error(t, validateSource(""))
+
+	output := filepath.Join(dir, "music", "new")
+	require.NoError(t, prepareOutput(output))
+	require.DirExists(t, output)
+	entries, err := os.ReadDir(output)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.Error(t, prepareOutput(links))
+
+	// Limits past the end of the list are cut to the links that exist.
+	read, _, err := getLinks(nil, links, 5, 1)
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://youtu.be/b", "https://youtu.be/c"}, read)
+	read, _, err = getLinks(nil, links, 0, 9)
+	require.NoError(t, err)
+	require.Empty(t, read)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportStatus is the outcome of a link in a run.
+type ReportStatus string
+
+const (
+	FetchFailed    ReportStatus = "fetched-failed"
+	Skipped        ReportStatus = "skipped"
+	Downloaded     ReportStatus = "downloaded"
+	DownloadFailed ReportStatus = "download-failed"
+)
+
+// ReportEntry describes what happened to one link of a run.
+type ReportEntry struct {
+	Link   string       `json:"link"`
+	Title  string       `json:"title,omitempty"`
+	Artist string       `json:"artist,omitempty"`
+	Status ReportStatus `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	// Warning describes problems that did not fail the download.
+	Warning string `json:"warning,omitempty"`
+	// Retries is the number of download attempts after the first one.
+	Retries int    `json:"retries"`
+	Path    string `json:"path,omitempty"`
+	// Duration of the download in seconds, including retries.
+	Duration float64 `json:"duration"`
+	Size     int64   `json:"size"`
+}
+
+var reportColumns = []string{"link", "title", "artist", "status", "error", "retries", "path", "duration", "size", "warning"}
+
+// downloadResult is kept for every finished download of a song.
+type downloadResult struct {
+	path     string
+	err      error
+	warning  string
+	retries  int
+	duration time.Duration
+	size     int64
+}
+
+func fetchReport(link string, err error) ReportEntry {
+	entry := ReportEntry{Link: link, Status: FetchFailed}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	return entry
+}
+
+func songReport(link string, s *Song, status ReportStatus, r downloadResult) ReportEntry {
+	entry := ReportEntry{
+		Link:     link,
+		Title:    s.Title,
+		Artist:   s.Artist,
+		Status:   status,
+		Warning:  r.warning,
+		Retries:  r.retries,
+		Path:     r.path,
+		Duration: r.duration.Seconds(),
+		Size:     r.size,
+	}
+	if r.err != nil {
+		entry.Error = r.err.Error()
+	}
+
+	return entry
+}
+
+// failedLinks returns links that could not be fetched or downloaded.
+func failedLinks(report []ReportEntry) []string {
+	links := make([]string, 0, len(report))
+	for _, entry := range report {
+		if entry.Status == FetchFailed || entry.Status == DownloadFailed {
+			links = append(links, entry.Link)
+		}
+	}
+
+	return links
+}
+
+// countStatus returns the number of report entries with the given status.
+func countStatus(report []ReportEntry, status ReportStatus) int {
+	n := 0
+	for _, entry := range report {
+		if entry.Status == status {
+			n += 1
+		}
+	}
+
+	return n
+}
+
+// WriteReport writes report.json and, with -report_csv, report.csv into the
+// output folder.
+func WriteReport(report []ReportEntry) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(filepath.Join(output, "report.json"), b, 0644); err != nil {
+		return fmt.Errorf("Could not write report file: %v", err)
+	}
+
+	if reportCSV == nil || !*reportCSV {
+		return nil
+	}
+
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Write(reportColumns)
+	for _, e := range report {
+		w.Write([]string{
+			e.Link, e.Title, e.Artist, string(e.Status), e.Error, strconv.Itoa(e.Retries),
+			e.Path, strconv.FormatFloat(e.Duration, 'f', 1, 64), strconv.FormatInt(e.Size, 10),
+			e.Warning,
+		})
+	}
+	w.Flush()
+
+	if err = os.WriteFile(filepath.Join(output, "report.csv"), []byte(sb.String()), 0644); err != nil {
+		return fmt.Errorf("Could not write report file: %v", err)
+	}
+
+	return nil
+}
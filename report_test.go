@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	song := &Song{Title: "Sandstorm", Artist: "Darude"}
+	report := []ReportEntry{
+		fetchReport("https://youtu.be/a", errors.New("Video unavailable")),
+		songReport("https://youtu.be/b", song, Skipped, downloadResult{}),
+		songReport("https://youtu.be/c", song, Downloaded, downloadResult{path: "Darude - Sandstorm.mp3", retries: 1, duration: 1500 * time.Millisecond, size: 2048, warning: "Saved without cover art."}),
+		songReport("https://youtu.be/d", song, DownloadFailed, downloadResult{err: errors.New("Could not create source file."), retries: 4}),
+	}
+
+	require.Equal(t, []string{"https://youtu.be/a", "https://youtu.be/d"}, failedLinks(report))
+	require.Equal(t, 1, countStatus(report, Downloaded))
+	require.Equal(t, 1.5, report[2].Duration)
+	require.Equal(t, "Could not create source file.", report[3].Error)
+
+	defer func(o string, c *bool) { output, reportCSV = o, c }(output, reportCSV)
+	output = t.TempDir()
+	withCSV := true
+	reportCSV = &withCSV
+	require.NoError(t, WriteReport(report))
+
+	b, err := os.ReadFile(filepath.Join(output, "report.json"))
+	require.NoError(t, err)
+	read := make([]ReportEntry, 0)
+	require.NoError(t, json.Unmarshal(b, &read))
+	require.Equal(t, report, read)
+
+	b, err = os.ReadFile(filepath.Join(output, "report.csv"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, len(report)+1)
+	require.Equal(t, "https://youtu.be/c,Sandstorm,Darude,downloaded,,1,Darude - Sandstorm.mp3,1.5,2048,Saved without cover art.", lines[3])
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/exp/slices"
+)
+
+// suffixRoom is kept free in file names for the extension and a rename
+// suffix such as " (2)".
+const suffixRoom = 16
+
+// FilenameProfile holds the file name rules of a target filesystem.
+type FilenameProfile struct {
+	Name string
+	// Characters that can not be used in file names, besides control
+	// characters.
+	invalid string
+	// Windows drops trailing dots and spaces and reserves device names.
+	windows bool
+	// Maximum bytes of a single file or folder name.
+	maxName int
+	// Maximum bytes of a path inside the output folder, 0 if unlimited.
+	maxPath int
+}
+
+var filenameProfiles = map[string]*FilenameProfile{
+	"posix":   {Name: "posix", invalid: "/", maxName: 255},
+	"windows": {Name: "windows", invalid: `<>:"/\|?*`, windows: true, maxName: 255},
+	// FAT32 has the rules of Windows and limits whole paths to 255
+	// characters, which USB sticks and car stereos often enforce.
+	"fat32": {Name: "fat32", invalid: `<>:"/\|?*`, windows: true, maxName: 255, maxPath: 255},
+}
+
+// windowsReserved are device names Windows does not allow as file names,
+// even with an extension.
+var windowsReserved = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+func ParseFilenameProfile(name string) (*FilenameProfile, error) {
+	profile, ok := filenameProfiles[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("Unknown filename profile \"%s\", expected posix, windows or fat32.", name)
+	}
+
+	return profile, nil
+}
+
+// defaultFilenameProfile returns the profile of the system yt2mp3 runs on.
+func defaultFilenameProfile() string {
+	if runtime.GOOS == "windows" {
+		return "windows"
+	}
+
+	return "posix"
+}
+
+// Sanitize makes name usable as a file or folder name, at most limit bytes
+// long. A limit of 0 only removes characters the profile does not allow.
+func (p *FilenameProfile) Sanitize(name string, limit int) string {
+	var b strings.Builder
+	space := false
+	for _, r := range name {
+		switch {
+		case r == utf8.RuneError, strings.ContainsRune(p.invalid, r):
+			continue
+		case unicode.IsSpace(r):
+			// Tabs and line breaks become a single space.
+			space = b.Len() > 0
+			continue
+		case unicode.IsControl(r):
+			continue
+		}
+
+		if space {
+			b.WriteRune(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+
+	name = p.trim(b.String())
+
+	if p.windows {
+		stem, _, _ := strings.Cut(name, ".")
+		if slices.Contains(windowsReserved, strings.ToUpper(strings.TrimSpace(stem))) {
+			name = stem + "_" + name[len(stem):]
+		}
+	}
+
+	if limit > 0 && len(name) > limit {
+		name = p.trim(truncateBytes(name, limit))
+	}
+
+	return name
+}
+
+// trim removes trailing spaces, and trailing dots which Windows drops.
+func (p *FilenameProfile) trim(name string) string {
+	if p.windows {
+		return strings.TrimRight(name, " .")
+	}
+
+	return strings.TrimRight(name, " ")
+}
+
+// truncateBytes cuts s to at most n bytes without splitting a rune.
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
+// Path sanitizes the folders and the file name of a path, given without
+// extension, keeping room for the extension.
+func (p *FilenameProfile) Path(segments []string) []string {
+	sanitized := make([]string, 0, len(segments))
+	length := 0
+	for i, segment := range segments {
+		limit := p.maxName
+		if i == len(segments)-1 {
+			limit -= suffixRoom
+			if p.maxPath > 0 && p.maxPath-length-suffixRoom < limit {
+				limit = max(p.maxPath-length-suffixRoom, 1)
+			}
+		}
+
+		if segment = p.Sanitize(segment, limit); segment != "" {
+			sanitized = append(sanitized, segment)
+			length += len(segment) + 1
+		}
+	}
+
+	return sanitized
+}
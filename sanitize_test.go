@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitize(t *testing.T) {
+	posix, windows := filenameProfiles["posix"], filenameProfiles["windows"]
+
+	require.Equal(t, `What "is" that?`, posix.Sanitize(`What "is" that?`, 0))
+	require.Equal(t, "What is that", windows.Sanitize(`What "is" that?`, 0))
+	require.Equal(t, "Hello, World", windows.Sanitize("Hello, World", 0))
+	require.Equal(t, ",", windows.Sanitize(`:,?*\/<>`, 0))
+	require.Equal(t, "ACDC", posix.Sanitize("AC/DC", 0))
+	require.Equal(t, "Line one two", posix.Sanitize(" Line\none\x00\t two ", 0))
+
+	require.Equal(t, "Ends with dots...", posix.Sanitize("Ends with dots...", 0))
+	require.Equal(t, "Ends with dots", windows.Sanitize("Ends with dots... ", 0))
+
+	require.Equal(t, "CON", posix.Sanitize("CON", 0))
+	require.Equal(t, "CON_", windows.Sanitize("CON", 0))
+	require.Equal(t, "nul_.remix", windows.Sanitize("nul.remix", 0))
+	require.Equal(t, "COM10", windows.Sanitize("COM10", 0))
+	require.Equal(t, "Console", windows.Sanitize("Console", 0))
+
+	// Truncation never splits a rune.
+	name := posix.Sanitize(strings.Repeat("é", 200), 255)
+	require.Len(t, name, 254)
+	require.True(t, utf8.ValidString(name))
+}
+
+func TestSanitizePath(t *testing.T) {
+	fat32 := filenameProfiles["fat32"]
+
+	segments := fat32.Path([]string{strings.Repeat("a", 200), strings.Repeat("b", 200)})
+	require.Len(t, segments[0], 200)
+	require.Len(t, segments[1], 255-201-suffixRoom)
+
+	segments = filenameProfiles["posix"].Path([]string{"Artist", strings.Repeat("b", 300)})
+	require.Len(t, segments[1], 255-suffixRoom)
+
+	require.Equal(t, []string{"Artist"}, fat32.Path([]string{"...", "Artist"}))
+}
+
+func TestParseFilenameProfile(t *testing.T) {
+	profile, err := ParseFilenameProfile("FAT32")
+	require.NoError(t, err)
+	require.Equal(t, "fat32", profile.Name)
+
+	_, err = ParseFilenameProfile("ntfs")
+	require.Error(t, err)
+}
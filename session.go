@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+)
+
+// Session is the part of the model needed to continue an interrupted run.
+type Session struct {
+	Source      string   `json:"source"`
+	Output      string   `json:"output"`
+	View        int      `json:"view"`
+	Links       []string `json:"links"`
+	Songs       []Song   `json:"songs"`
+	Album       Album    `json:"album"`
+	Fetched     []bool   `json:"fetched"`
+	FailedFetch int      `json:"failed_fetch"`
+	// FetchErrors are kept for the report of the resumed run.
+	FetchErrors []ReportEntry `json:"fetch_errors,omitempty"`
+	Archived    int           `json:"archived"`
+	Status      []Status      `json:"status"`
+	// Errors holds why each song failed or was skipped, empty for the rest.
+	Errors    []string `json:"errors,omitempty"`
+	EditIndx  int      `json:"edit_index"`
+	EditQueue []int    `json:"edit_queue,omitempty"`
+}
+
+func LoadSession(path string) (*Session, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read session file: %v", err)
+	}
+
+	var session Session
+	if err = json.Unmarshal(b, &session); err != nil {
+		return nil, fmt.Errorf("Could not parse session file %s: %v", path, err)
+	}
+
+	if len(session.Songs) != len(session.Links) || len(session.Fetched) != len(session.Links) {
+		return nil, fmt.Errorf("Session file %s is corrupted.", path)
+	}
+
+	return &session, nil
+}
+
+// checkpoint writes the session file, so the run can be resumed after a crash
+// or quit. Finished runs have nothing left to resume.
+func (m *model) checkpoint() {
+	if sessionPath == "" || m.view == int(finish) {
+		return
+	}
+
+	session := Session{
+		Source:      source,
+		Output:      output,
+		View:        m.view,
+		Links:       m.links,
+		Songs:       make([]Song, len(m.songs)),
+		Album:       m.album,
+		Fetched:     m.fetched,
+		FailedFetch: m.failedFetch,
+		FetchErrors: m.fetchErrors,
+		Archived:    m.archived,
+		Status:      m.status,
+		EditIndx:    m.editIndx,
+		EditQueue:   m.editQueue,
+	}
+
+	// Stream URLs expire after a few hours, so only the video details are
+	// kept and the rest is fetched again when the song is downloaded.
+	for i, song := range m.songs {
+		song.Video = trimVideo(song.Video)
+		song.Fetched = time.Time{}
+		session.Songs[i] = song
+	}
+
+	for i, result := range m.results {
+		if result.err == nil {
+			continue
+		}
+		if session.Errors == nil {
+			session.Errors = make([]string, len(m.songs))
+		}
+		session.Errors[i] = result.err.Error()
+	}
+
+	// Once metadata is fetched, links and songs are filtered to fetched ones.
+	if m.view != int(fetch) {
+		session.Fetched = make([]bool, len(m.links))
+		for i := range session.Fetched {
+			session.Fetched[i] = true
+		}
+	}
+
+	if err := writeSession(sessionPath, &session); err != nil {
+		m.err = err
+	}
+}
+
+func writeSession(path string, session *Session) error {
+	b, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Could not write session file: %v", err)
+	}
+
+	if err = writeFileAtomic(path, b); err != nil {
+		return fmt.Errorf("Could not write session file: %v", err)
+	}
+
+	return nil
+}
+
+// restore builds the model state saved in the session. Songs that were
+// queued or being downloaded are queued again, links that failed to fetch
+// are fetched again.
+func (s *Session) restore() model {
+	m := model{
+		view:        s.View,
+		links:       s.Links,
+		songs:       s.Songs,
+		album:       s.Album,
+		fetched:     s.Fetched,
+		failedFetch: s.FailedFetch,
+		fetchErrors: s.FetchErrors,
+		archived:    s.Archived,
+		status:      s.Status,
+		editIndx:    s.EditIndx,
+		editQueue:   s.EditQueue,
+	}
+
+	if m.view == int(fetch) {
+		// Links that failed are fetched again.
+		m.failedFetch = 0
+		m.fetchErrors = nil
+		for i, ok := range m.fetched {
+			if ok {
+				m.fetchCount += 1
+			} else {
+				m.fetchQueue = append(m.fetchQueue, i)
+			}
+		}
+		m.fetchPercent = float64(m.fetchCount) / float64(len(m.links))
+
+		return m
+	}
+
+	m.view = int(edit)
+	if len(m.status) != len(m.songs) {
+		m.status = make([]Status, len(m.songs))
+	}
+	m.results = make([]downloadResult, len(m.songs))
+	for i, text := range s.Errors {
+		if text != "" && i < len(m.results) {
+			m.results[i].err = errors.New(text)
+		}
+	}
+
+	for i, status := range m.status {
+		switch status {
+		case queued:
+			m.enqueue(i)
+		case downloaded:
+			m.downloadCount += 1
+		case downloadFailed:
+			m.failedCount += 1
+		case skipped:
+			m.skipCount += 1
+		}
+	}
+
+	if len(m.songs) > 0 {
+		m.editPercent = float64(m.editIndx) / float64(len(m.songs))
+		m.downloadPercent = float64(m.downloadCount+m.failedCount+m.skipCount) / float64(len(m.songs))
+	}
+
+	return m
+}
+
+func trimVideo(v *youtube.Video) *youtube.Video {
+	if v == nil {
+		return nil
+	}
+
+	return &youtube.Video{
+		ID:          v.ID,
+		Title:       v.Title,
+		Author:      v.Author,
+		Duration:    v.Duration,
+		PublishDate: v.PublishDate,
+		Thumbnails:  v.Thumbnails,
+	}
+}
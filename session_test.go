@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	sessionPath = filepath.Join(t.TempDir(), "session.json")
+	defer func() { sessionPath = "" }()
+
+	video := &youtube.Video{ID: "jH1RNk8954Q", Title: "Ava Max - Kings & Queens", Formats: youtube.FormatList{{ItagNo: 140}}}
+	m := model{
+		view:     int(edit),
+		links:    []string{"a", "b", "c", "d", "f"},
+		songs:    []Song{{Title: "A", Video: video, Fetched: time.Now()}, {Title: "B"}, {Title: "C"}, {Title: "D"}, {Title: "F"}},
+		fetched:  []bool{true, true, true, true, true},
+		status:   []Status{downloaded, queued, skipped, pending, downloadFailed},
+		results:  make([]downloadResult, 5),
+		editIndx: 3,
+
+		failedFetch: 1,
+		fetchErrors: []ReportEntry{fetchReport("e", errors.New("Video unavailable"))},
+	}
+	m.results[4].err = errors.New("Could not read video stream.")
+	m.checkpoint()
+	require.NoError(t, m.err)
+
+	session, err := LoadSession(sessionPath)
+	require.NoError(t, err)
+
+	restored := session.restore()
+	require.Equal(t, int(edit), restored.view)
+	require.Equal(t, 3, restored.editIndx)
+	require.Equal(t, []int{1}, restored.queue)
+	require.Equal(t, 1, restored.failedCount)
+	require.Equal(t, 1, restored.downloadCount)
+	require.Equal(t, 1, restored.skipCount)
+	require.Equal(t, m.fetchErrors, restored.fetchErrors)
+	require.Equal(t, FetchFailed, restored.report()[0].Status)
+
+	// Failure reasons survive the restart.
+	require.Equal(t, "Could not read video stream.", restored.failures()[0].err)
+	require.Equal(t, "Could not read video stream.", restored.report()[5].Error)
+
+	// Stream formats are dropped and fetched again before downloading.
+	require.Equal(t, "jH1RNk8954Q", restored.songs[0].Video.ID)
+	require.Empty(t, restored.songs[0].Video.Formats)
+	require.True(t, restored.songs[0].Fetched.IsZero())
+}
+
+func TestSessionRestoreFetch(t *testing.T) {
+	session := Session{
+		View:    int(fetch),
+		Links:   []string{"a", "b", "c"},
+		Songs:   make([]Song, 3),
+		Fetched: []bool{true, false, true},
+
+		FailedFetch: 1,
+		FetchErrors: []ReportEntry{fetchReport("b", errors.New("Video unavailable"))},
+	}
+
+	m := session.restore()
+	require.Equal(t, []int{1}, m.fetchQueue)
+	require.Equal(t, 2, m.fetchCount)
+	require.Empty(t, m.fetchErrors)
+}
@@ -1,17 +1,17 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"io/fs"
-	"io/ioutil"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
-	id3 "github.com/bogem/id3v2"
 	"github.com/kkdai/youtube/v2"
 	"golang.org/x/exp/slices"
 )
@@ -24,100 +24,308 @@ const (
 	No
 )
 
+var reliableNames = []string{"yes", "maybe", "no"}
+
+func (r Reliable) String() string {
+	if r < Yes || r > No {
+		return "unknown"
+	}
+
+	return reliableNames[r]
+}
+
 type Song struct {
-	Title    string
-	Artist   string
-	Video    *youtube.Video
-	Content  []byte
-	Reliable Reliable
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Year        int
+	Genre       string
+	TrackNumber int
+	Video       *youtube.Video
+	Reliable    Reliable
+	// Fetched is when Video was fetched, its stream URLs expire after a while.
+	Fetched time.Time
 }
 
-func (s *Song) Save(path string) error {
-	reader, _, err := client.GetStream(s.Video, FindFormat(s.Video.Formats))
-	if err != nil {
-		return fmt.Errorf("Could not get video stream from song \"%s - %s\"", s.Artist, s.Title)
+// videoTTL is how long stream URLs of a fetched video can be used.
+const videoTTL = 5 * time.Hour
+
+// URL returns the link of the video the song was taken from.
+func (s *Song) URL() string {
+	return fmt.Sprintf("https://www.youtube.com/watch?v=%s", s.Video.ID)
+}
+
+// Filename returns the file the song would be saved to inside folder if it
+// was saved now, renamed if the conflict policy asks for it, and whether the
+// file is taken, so the song would be skipped, replace it or be compared
+// with it. Unlike Title and Artist, which are written to tags as they are, it
+// only contains characters the output filesystem allows.
+func (s *Song) Filename(folder string, output OutputFormat) (string, bool) {
+	fname := filepath.Join(folder, output.Template.Path(s))
+	path, taken := claims.lookup(fname, output.Extension, s.Video.ID, output.Conflict)
+	if rel, err := filepath.Rel(folder, path); err == nil {
+		path = rel
 	}
 
-	s.Content, err = io.ReadAll(reader)
+	return path, taken
+}
+
+// Hold claims the file of a song confirmed for download inside folder, so
+// songs confirmed later see it as taken. Save resolves it for good.
+func (s *Song) Hold(folder string, output OutputFormat) {
+	if s.Video == nil {
+		return
+	}
+
+	claims.hold(filepath.Join(folder, output.Template.Path(s)), output.Extension, s.Video.ID, output.Conflict)
+}
+
+// ProgressFunc is called while a song is being downloaded with the number of
+// bytes received so far and the total size of the stream.
+type ProgressFunc func(received int64, total int64)
+
+// progressWriter counts bytes written through it and reports them to a
+// ProgressFunc at most once per progressInterval.
+type progressWriter struct {
+	received int64
+	total    int64
+	last     time.Time
+	report   ProgressFunc
+}
+
+const progressInterval = 100 * time.Millisecond
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.received += int64(len(p))
+	if w.report != nil && (time.Since(w.last) >= progressInterval || w.received == w.total) {
+		w.last = time.Now()
+		w.report(w.received, w.total)
+	}
+
+	return len(p), nil
+}
+
+// Save downloads the song and returns the path of the saved audio file and a
+// warning about problems that did not fail the song, such as a missing cover.
+// Cancelling ctx stops the download and conversion and removes partial files.
+func (s *Song) Save(ctx context.Context, path string, output OutputFormat, progress ProgressFunc) (string, string, error) {
+	fname := filepath.Join(path, output.Template.Path(s))
+
+	// Collisions are resolved before anything is downloaded.
+	audio, err := claims.reserve(ctx, fname, output.Extension, s.Video.ID, output.Conflict)
 	if err != nil {
-		return fmt.Errorf("Could not read video stream from song \"%s - %s\"", s.Artist, s.Title)
+		return "", "", err
 	}
+	saved := false
+	defer func() {
+		if saved {
+			claims.finish(audio, s.Video.ID)
+		} else {
+			claims.release(audio, s.Video.ID)
+		}
+	}()
 
-	reader.Close()
-	fname := fmt.Sprintf("%s%s - %s", path, s.Artist, s.Title)
+	if err = os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return "", "", fmt.Errorf("Could not create folder for song \"%s - %s\": %v", s.Artist, s.Title, err)
+	}
 
-	err = ioutil.WriteFile(fmt.Sprintf("%s.mp4", fname), s.Content, fs.ModePerm)
+	// Songs restored from a session or waiting for long have expired stream URLs.
+	video := s.Video
+	if time.Since(s.Fetched) > videoTTL {
+		if video, err = client.GetVideoContext(ctx, s.URL()); err != nil {
+			return "", "", fmt.Errorf("Could not fetch video of song \"%s - %s\": %v", s.Artist, s.Title, err)
+		}
+	}
+
+	format, err := FindFormat(video.Formats, output.Preference, output.Sources...)
 	if err != nil {
-		return fmt.Errorf("Could not save mp4 file.")
+		return "", "", fmt.Errorf("Could not find audio stream for song \"%s - %s\": %v", s.Artist, s.Title, err)
 	}
 
-	mp4 := fmt.Sprintf("%s.mp4", fname)
-	mp3 := fmt.Sprintf("%s.mp3", fname)
+	reader, size, err := client.GetStreamContext(ctx, video, format)
+	if err != nil {
+		return "", "", fmt.Errorf("Could not get video stream from song \"%s - %s\"", s.Artist, s.Title)
+	}
+	defer reader.Close()
 
-	cmd := exec.Command("ffmpeg", "-y", "-i", mp4, "-vn", mp3)
+	if size <= 0 {
+		size = format.ContentLength
+	}
 
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	// Stream straight to a temporary file so the song is never held in memory.
+	file, err := os.CreateTemp(filepath.Dir(fname), ".yt2mp3-*."+sourceExtension(format))
+	if err != nil {
+		return "", "", fmt.Errorf("Could not create source file.")
+	}
+	source := file.Name()
+	defer os.Remove(source)
 
-	err = cmd.Run()
+	_, err = io.Copy(io.MultiWriter(file, &progressWriter{total: size, report: progress}), reader)
+	file.Close()
 	if err != nil {
-		return fmt.Errorf("%v: %s", err, stderr.String())
+		return "", "", fmt.Errorf("Could not read video stream from song \"%s - %s\"", s.Artist, s.Title)
 	}
 
-	if err = os.Remove(mp4); err != nil {
-		return fmt.Errorf("Could not remove mp4 file.")
+	// Convert next to the source, the song only takes its name once complete.
+	file, err = os.CreateTemp(filepath.Dir(fname), ".yt2mp3-*."+output.Extension)
+	if err != nil {
+		return "", "", fmt.Errorf("Could not create audio file.")
 	}
+	file.Close()
+	converted := file.Name()
+	defer os.Remove(converted)
 
-	tag, err := id3.Open(mp3, id3.Options{Parse: true})
+	if err = output.Encoder.Encode(ctx, source, converted, format, output.Quality); err != nil {
+		return "", "", err
+	}
+
+	// Cover art is optional, a missing thumbnail should not fail the song.
+	warning := ""
+	cover, err := FetchCover(ctx, s.Video, output.Cover)
 	if err != nil {
-		return fmt.Errorf("Could not open mp3 file to edit metadata.")
+		cover = nil
+		warning = fmt.Sprintf("Saved without cover art. %v", err)
 	}
-	defer tag.Close()
 
-	tag.SetArtist(s.Artist)
-	tag.SetTitle(s.Title)
+	if err = output.Tagger.Tag(ctx, converted, s, output.Quality, cover); err != nil {
+		return "", "", err
+	}
 
-	if err = tag.Save(); err != nil {
-		return fmt.Errorf("Could not save edited metadata.")
+	if err = claims.place(ctx, converted, audio, output.Conflict, downloadInfo(video, format, output.Quality)); err != nil {
+		return "", "", err
 	}
+	saved = true
 
-	return nil
+	return audio, warning, nil
 }
 
-func FindFormat(formats youtube.FormatList) *youtube.Format {
+// SourcePreference decides which source stream is downloaded when a video
+// offers more than one.
+type SourcePreference int
+
+const (
+	// Prefer streams the output format can use without transcoding.
+	PreferCodec SourcePreference = iota
+	// Prefer the highest bitrate, sample rate and channel count.
+	PreferQuality
+	// Prefer the smallest stream.
+	PreferSize
+)
+
+var sourcePreferences = map[string]SourcePreference{
+	"codec":   PreferCodec,
+	"quality": PreferQuality,
+	"size":    PreferSize,
+}
+
+func ParseSourcePreference(name string) (SourcePreference, error) {
+	preference, ok := sourcePreferences[strings.ToLower(name)]
+	if !ok {
+		return PreferCodec, fmt.Errorf("Unknown source preference \"%s\", expected codec, quality or size.", name)
+	}
+
+	return preference, nil
+}
+
+// RankFormats returns formats carrying audio ordered from best to worst.
+// Audio only streams always rank above muxed audio and video streams. Codecs
+// lists mime types the output format prefers, best first.
+func RankFormats(formats youtube.FormatList, preference SourcePreference, codecs ...string) youtube.FormatList {
+	ranked := make(youtube.FormatList, 0, len(formats))
 	for _, format := range formats {
-		if format.MimeType == "audio/mp4; codecs=\"mp4a.40.2\"" {
-			return &format
+		if isAudioOnly(format) || format.AudioChannels > 0 {
+			ranked = append(ranked, format)
 		}
 	}
 
-	return nil
-}
+	codecRank := func(format youtube.Format) int {
+		if i := slices.Index(codecs, format.MimeType); i >= 0 {
+			return i
+		}
+		return len(codecs)
+	}
 
-func RemoveSpecialChars(s string) string {
+	// Compare quality by bitrate first, then sample rate and channels.
+	compareQuality := func(a, b youtube.Format) int {
+		if d := formatBitrate(a) - formatBitrate(b); d != 0 {
+			return d
+		}
+		as, _ := strconv.Atoi(a.AudioSampleRate)
+		bs, _ := strconv.Atoi(b.AudioSampleRate)
+		if as != bs {
+			return as - bs
+		}
+		return a.AudioChannels - b.AudioChannels
+	}
 
-	sb := []byte(s)
-	special := []byte("<>,:\"/\\|?*")
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if isAudioOnly(a) != isAudioOnly(b) {
+			return isAudioOnly(a)
+		}
 
-	n := 0
-	for i := 0; i < len(sb); i++ {
-		if !slices.Contains(special, s[i]) {
-			sb[n] = sb[i]
-			n++
+		switch preference {
+		case PreferQuality:
+			return compareQuality(a, b) > 0
+		case PreferSize:
+			return compareQuality(a, b) < 0
+		default:
+			if codecRank(a) != codecRank(b) {
+				return codecRank(a) < codecRank(b)
+			}
+			return compareQuality(a, b) > 0
 		}
+	})
+
+	return ranked
+}
+
+// FindFormat returns the best ranked format carrying audio.
+func FindFormat(formats youtube.FormatList, preference SourcePreference, codecs ...string) (*youtube.Format, error) {
+	ranked := RankFormats(formats, preference, codecs...)
+	if len(ranked) == 0 {
+		return nil, fmt.Errorf("No audio stream available.")
 	}
 
-	return string(sb[:n])
+	return &ranked[0], nil
 }
 
-func GetSong(client *youtube.Client, link string) (*Song, error) {
-	video, err := client.GetVideo(link)
+func isAudioOnly(format youtube.Format) bool {
+	return strings.HasPrefix(format.MimeType, "audio/")
+}
+
+func formatBitrate(format youtube.Format) int {
+	if format.AverageBitrate > 0 {
+		return format.AverageBitrate
+	}
+
+	return format.Bitrate
+}
+
+// sourceExtension returns the container extension of a format, e.g. "webm"
+// for "audio/webm; codecs=\"opus\"".
+func sourceExtension(format *youtube.Format) string {
+	mimeType, _, _ := strings.Cut(format.MimeType, ";")
+	if _, ext, ok := strings.Cut(mimeType, "/"); ok {
+		return ext
+	}
+
+	return "mp4"
+}
+
+func GetSong(ctx context.Context, client *youtube.Client, link string) (*Song, error) {
+	video, err := client.GetVideoContext(ctx, link)
 	if err != nil {
 		return nil, err
 	}
 
 	song := ParseMetadata(video.Title, video.Author)
 	song.Video = video
+	song.Fetched = time.Now()
+	if !video.PublishDate.IsZero() {
+		song.Year = video.PublishDate.Year()
+	}
 
 	return song, nil
 }
@@ -163,10 +371,6 @@ func ParseMetadata(title string, author string) *Song {
 		song.Reliable = No
 	}
 
-	// Remove special characters for file saving.
-	song.Artist = RemoveSpecialChars(song.Artist)
-	song.Title = RemoveSpecialChars(song.Title)
-
 	// Remove redundant spaces.
 	regex = regexp.MustCompile(` {2,}`)
 	song.Artist = regex.ReplaceAllLiteralString(song.Artist, " ")
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/kkdai/youtube/v2"
 	"github.com/stretchr/testify/require"
 )
 
@@ -14,16 +17,6 @@ type testVideo struct {
 	reliable    Reliable
 }
 
-func TestRemoveSpacialChars(t *testing.T) {
-
-	output := []string{"What is that", ""}
-	input := []string{"What \"is\" that?", ":,?*\\/<>"}
-
-	for i := 0; i < len(output); i++ {
-		require.Equal(t, output[i], RemoveSpecialChars(input[i]))
-	}
-}
-
 func TestParseTitle(t *testing.T) {
 	testVideos := []testVideo{
 		{
@@ -92,14 +85,14 @@ func TestParseTitle(t *testing.T) {
 		{
 			"Sting - What Could Have Been | Arcane League of Legends | Riot Games Music",
 			"Riot Games Music",
-			"What Could Have Been Arcane League of Legends Riot Games Music",
+			"What Could Have Been | Arcane League of Legends | Riot Games Music",
 			"Sting",
 			Maybe,
 		},
 		{
 			"TECHNO MIX 2021 | DJD3",
 			"DJD3",
-			"TECHNO MIX 2021 DJD3",
+			"TECHNO MIX 2021 | DJD3",
 			"DJD3",
 			No,
 		},
@@ -117,6 +110,13 @@ func TestParseTitle(t *testing.T) {
 			`Wu-Tang Clan`,
 			Yes,
 		},
+		{
+			`Kevin Abstract - What "is" that?`,
+			`Kevin Abstract`,
+			`What "is" that?`,
+			`Kevin Abstract`,
+			Yes,
+		},
 		{
 			`Calle`,
 			`El Mola - Topic`,
@@ -133,3 +133,96 @@ func TestParseTitle(t *testing.T) {
 		require.Equal(t, song.reliable, video.Reliable)
 	}
 }
+
+func TestFilename(t *testing.T) {
+	song := ParseMetadata(`Kevin Abstract - What "is" that?`, "Kevin Abstract")
+	song.Video = &youtube.Video{ID: "id"}
+
+	tmpl, err := ParseTemplate(defaultTemplate, filenameProfiles["windows"])
+	require.NoError(t, err)
+	output := OutputFormat{Extension: "mp3", Template: tmpl, Conflict: ConflictRename}
+	folder := t.TempDir()
+
+	fname, taken := song.Filename(folder, output)
+	require.Equal(t, "Kevin Abstract - What is that.mp3", fname)
+	require.False(t, taken)
+	require.Equal(t, `What "is" that?`, song.Title)
+
+	// The preview follows the conflict policy without reserving the file.
+	require.NoError(t, os.WriteFile(filepath.Join(folder, fname), nil, 0644))
+	fname, taken = song.Filename(folder, output)
+	require.Equal(t, "Kevin Abstract - What is that (2).mp3", fname)
+	require.False(t, taken)
+
+	output.Conflict = ConflictSkip
+	fname, taken = song.Filename(folder, output)
+	require.Equal(t, "Kevin Abstract - What is that.mp3", fname)
+	require.True(t, taken)
+	require.NotContains(t, claims.claims, filepath.Join(folder, fname))
+}
+
+func TestRankFormats(t *testing.T) {
+	aac := youtube.Format{ItagNo: 140, MimeType: mimeAAC, Bitrate: 130000, AudioSampleRate: "44100", AudioChannels: 2}
+	opus := youtube.Format{ItagNo: 251, MimeType: mimeOpus, Bitrate: 160000, AudioSampleRate: "48000", AudioChannels: 2}
+	lowOpus := youtube.Format{ItagNo: 249, MimeType: mimeOpus, Bitrate: 50000, AudioSampleRate: "48000", AudioChannels: 2}
+	muxed := youtube.Format{ItagNo: 18, MimeType: "video/mp4; codecs=\"avc1.42001E, mp4a.40.2\"", Bitrate: 500000, AudioChannels: 2}
+	video := youtube.Format{ItagNo: 137, MimeType: "video/mp4; codecs=\"avc1.640028\"", Bitrate: 4000000}
+
+	formats := youtube.FormatList{muxed, video, lowOpus, aac, opus}
+
+	ranked := RankFormats(formats, PreferCodec, mimeAAC)
+	require.Equal(t, []int{140, 251, 249, 18}, itags(ranked))
+
+	ranked = RankFormats(formats, PreferQuality)
+	require.Equal(t, []int{251, 140, 249, 18}, itags(ranked))
+
+	ranked = RankFormats(formats, PreferSize)
+	require.Equal(t, []int{249, 140, 251, 18}, itags(ranked))
+
+	format, err := FindFormat(youtube.FormatList{video, muxed}, PreferCodec, mimeAAC)
+	require.NoError(t, err)
+	require.Equal(t, 18, format.ItagNo)
+
+	_, err = FindFormat(youtube.FormatList{video}, PreferCodec)
+	require.Error(t, err)
+}
+
+func itags(formats youtube.FormatList) []int {
+	r := make([]int, 0, len(formats))
+	for _, format := range formats {
+		r = append(r, format.ItagNo)
+	}
+
+	return r
+}
+
+func TestProgressWriter(t *testing.T) {
+	reports := make([]transfer, 0)
+	report := func(received int64, total int64) {
+		reports = append(reports, transfer{received: received, total: total})
+	}
+
+	// Reports are throttled, but the last chunk is always reported.
+	w := &progressWriter{total: 10, report: report}
+	for _, chunk := range []string{"012", "345", "6789"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		require.Equal(t, len(chunk), n)
+	}
+	require.Equal(t, []transfer{{received: 3, total: 10}, {received: 10, total: 10}}, reports)
+	require.Equal(t, 30.0, reports[0].percent())
+	require.Equal(t, 100.0, reports[1].percent())
+
+	// Streams without Content-Length report a total of 0.
+	reports = reports[:0]
+	w = &progressWriter{report: report}
+	w.Write([]byte("01234"))
+	require.Equal(t, []transfer{{received: 5}}, reports)
+
+	m := model{transfers: make(map[int]transfer)}
+	next, _ := updateEditor(progressMsg{index: 0, received: 5, total: 0}, m)
+	require.Equal(t, 0.0, next.(model).transfers[0].percent())
+
+	// A wrong Content-Length does not go past 100%.
+	require.Equal(t, 100.0, transfer{received: 12, total: 10}.percent())
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// removedFolder collects files of videos that were removed from a synced playlist.
+const removedFolder = "_removed"
+
+// syncPlan compares a playlist with the archive of its output folder.
+type syncPlan struct {
+	// Links of new videos and of archived videos whose file is missing.
+	links   []string
+	missing int
+	current int
+	// Archived videos of the playlist that are no longer in it.
+	removed []ArchiveEntry
+	// Archived videos of the playlist that did not record it yet.
+	adopted int
+}
+
+// planSync compares the links of a playlist with the archive. Only videos
+// downloaded from the same playlist count as removed, the archive can also
+// hold songs of other playlists and link files.
+func planSync(archive *Archive, links []string, playlist string) syncPlan {
+	plan := syncPlan{links: make([]string, 0, len(links))}
+
+	inPlaylist := make(map[string]bool, len(links))
+	for _, link := range links {
+		entry, ok := archive.Entry(link)
+		if !ok {
+			plan.links = append(plan.links, link)
+			continue
+		}
+
+		inPlaylist[entry.ID] = true
+		// Archives written before playlists were recorded learn them here.
+		if entry.Playlist == "" {
+			entry.Playlist = playlist
+			archive.entries[entry.ID] = entry
+			plan.adopted += 1
+		}
+		if _, err := os.Stat(entry.Path); entry.Removed || err != nil {
+			plan.links = append(plan.links, link)
+			plan.missing += 1
+			continue
+		}
+
+		plan.current += 1
+	}
+
+	for id, entry := range archive.entries {
+		if !inPlaylist[id] && !entry.Removed && entry.Playlist == playlist {
+			plan.removed = append(plan.removed, entry)
+		}
+	}
+
+	return plan
+}
+
+// moveRemoved moves files of removed videos into the removed folder inside
+// the output folder, keeping their path relative to the output folder, and
+// records the new location in the archive. Files already in the removed
+// folder are handled by the conflict policy. It returns the number of moved
+// files.
+func moveRemoved(ctx context.Context, archive *Archive, output string, entries []ArchiveEntry, policy ConflictPolicy) (int, error) {
+	folder := filepath.Join(output, removedFolder)
+	moved := 0
+
+	for _, entry := range entries {
+		path, err := removedPath(ctx, folder, output, entry.Path, policy)
+		if err != nil {
+			return moved, err
+		}
+		if path == "" {
+			continue
+		}
+
+		err = os.Rename(entry.Path, path)
+		if errors.Is(err, fs.ErrNotExist) {
+			path = entry.Path
+		} else if err != nil {
+			return moved, fmt.Errorf("Could not move %s: %v", entry.Path, err)
+		} else {
+			moved += 1
+		}
+
+		entry.Path = path
+		entry.Removed = true
+		archive.entries[entry.ID] = entry
+	}
+
+	return moved, archive.save()
+}
+
+// removedPath returns where the file at src goes inside the removed folder,
+// or "" when it stays where it is.
+func removedPath(ctx context.Context, folder string, output string, src string, policy ConflictPolicy) (string, error) {
+	rel := filepath.Base(src)
+	if abs, err := filepath.Abs(output); err == nil {
+		if r, err := filepath.Rel(abs, src); err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			rel = r
+		}
+	}
+
+	path := filepath.Join(folder, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("Could not create %s folder: %v", removedFolder, err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return path, nil
+	}
+
+	switch policy {
+	case ConflictSkip:
+		return "", nil
+	case ConflictRename:
+		extension := filepath.Ext(path)
+		return freePath(strings.TrimSuffix(path, extension), strings.TrimPrefix(extension, "."), func(path string) bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}), nil
+	case ConflictCompare:
+		// The file only replaces a shorter or lower bitrate one, otherwise it
+		// stays where it is, as it does when either can not be probed.
+		existing, err := probeAudio(ctx, path)
+		if err != nil {
+			return "", nil
+		}
+		moving, err := probeAudio(ctx, src)
+		if err != nil || !moving.better(existing) {
+			return "", nil
+		}
+	}
+
+	return path, nil
+}
+
+// syncLinks returns playlist links that need to be downloaded to mirror the
+// playlist in the output folder.
+func syncLinks(ctx context.Context, archive *Archive, links []string, output string, move bool, policy ConflictPolicy) ([]string, error) {
+	plan := planSync(archive, links, playlistID(source))
+	if plan.adopted > 0 {
+		if err := archive.save(); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Printf("%d new • %d missing • %d up to date • %d removed\n", len(plan.links)-plan.missing, plan.missing, plan.current, len(plan.removed))
+
+	if move && len(plan.removed) > 0 {
+		moved, err := moveRemoved(ctx, archive, output, plan.removed, policy)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Moved %d removed songs to %s.\n", moved, filepath.Join(output, removedFolder))
+	}
+
+	return plan.links, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSync(t *testing.T) {
+	output := t.TempDir()
+	archive, err := LoadArchive(filepath.Join(output, "archive.json"))
+	require.NoError(t, err)
+
+	current := filepath.Join(output, "current.mp3")
+	removed := filepath.Join(output, "removed.mp3")
+	require.NoError(t, os.WriteFile(current, nil, 0644))
+	require.NoError(t, os.WriteFile(removed, nil, 0644))
+
+	archive.entries["aaaaaaaaaaa"] = ArchiveEntry{ID: "aaaaaaaaaaa", Path: current}
+	archive.entries["bbbbbbbbbbb"] = ArchiveEntry{ID: "bbbbbbbbbbb", Path: filepath.Join(output, "missing.mp3"), Title: "Ledena"}
+	archive.entries["ccccccccccc"] = ArchiveEntry{ID: "ccccccccccc", Path: removed, Playlist: "PL1"}
+	// Songs of other playlists and link files are never removed.
+	archive.entries["eeeeeeeeeee"] = ArchiveEntry{ID: "eeeeeeeeeee", Path: removed, Playlist: "PL2"}
+	archive.entries["fffffffffff"] = ArchiveEntry{ID: "fffffffffff", Path: removed}
+
+	links := []string{
+		"https://www.youtube.com/watch?v=aaaaaaaaaaa",
+		"https://www.youtube.com/watch?v=bbbbbbbbbbb",
+		"https://www.youtube.com/watch?v=ddddddddddd",
+	}
+
+	plan := planSync(archive, links, "PL1")
+	require.Equal(t, links[1:], plan.links)
+	require.Equal(t, 1, plan.missing)
+	require.Equal(t, 1, plan.current)
+	require.Len(t, plan.removed, 1)
+	require.Equal(t, "ccccccccccc", plan.removed[0].ID)
+	require.Equal(t, 2, plan.adopted)
+	require.Equal(t, "PL1", archive.entries["aaaaaaaaaaa"].Playlist)
+
+	moved, err := moveRemoved(context.Background(), archive, output, plan.removed, ConflictRename)
+	require.NoError(t, err)
+	require.Equal(t, 1, moved)
+	require.FileExists(t, filepath.Join(output, removedFolder, "removed.mp3"))
+	require.True(t, archive.entries["ccccccccccc"].Removed)
+
+	// Removed songs are not reported again.
+	require.Empty(t, planSync(archive, links, "PL1").removed)
+
+	// Songs downloaded again keep metadata confirmed earlier.
+	song := Song{Title: "Parsed title"}
+	entry, ok := archive.Entry(links[1])
+	require.True(t, ok)
+	entry.apply(&song)
+	require.Equal(t, "Ledena", song.Title)
+}
+
+func TestMoveRemovedNested(t *testing.T) {
+	output := t.TempDir()
+	archive, err := LoadArchive(filepath.Join(output, "archive.json"))
+	require.NoError(t, err)
+
+	first := filepath.Join(output, "A", "Album1", "01 - Intro.mp3")
+	second := filepath.Join(output, "B", "Album2", "01 - Intro.mp3")
+	third := filepath.Join(output, "C", "01 - Intro.mp3")
+	for _, path := range []string{first, second, third} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(path), 0644))
+	}
+
+	// An earlier sync already moved a song to the same place as the third.
+	existing := filepath.Join(output, removedFolder, "C", "01 - Intro.mp3")
+	require.NoError(t, os.MkdirAll(filepath.Dir(existing), 0755))
+	require.NoError(t, os.WriteFile(existing, []byte("existing"), 0644))
+
+	entries := []ArchiveEntry{{ID: "aaaaaaaaaaa", Path: first}, {ID: "bbbbbbbbbbb", Path: second}, {ID: "ccccccccccc", Path: third}}
+	moved, err := moveRemoved(context.Background(), archive, output, entries, ConflictRename)
+	require.NoError(t, err)
+	require.Equal(t, 3, moved)
+
+	require.FileExists(t, filepath.Join(output, removedFolder, "A", "Album1", "01 - Intro.mp3"))
+	require.FileExists(t, filepath.Join(output, removedFolder, "B", "Album2", "01 - Intro.mp3"))
+	require.Equal(t, filepath.Join(output, removedFolder, "C", "01 - Intro (2).mp3"), archive.entries["ccccccccccc"].Path)
+
+	b, err := os.ReadFile(existing)
+	require.NoError(t, err)
+	require.Equal(t, "existing", string(b))
+
+	// Skipped songs stay where they are.
+	require.NoError(t, os.WriteFile(third, []byte("again"), 0644))
+	moved, err = moveRemoved(context.Background(), archive, output, []ArchiveEntry{{ID: "ddddddddddd", Path: third}}, ConflictSkip)
+	require.NoError(t, err)
+	require.Zero(t, moved)
+	require.FileExists(t, third)
+	require.False(t, archive.entries["ddddddddddd"].Removed)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// defaultTemplate names files the way they were named before templates.
+const defaultTemplate = "{artist} - {title}"
+
+// Template builds the path of a song inside the output folder from song
+// fields such as {artist} or {track:02}. Folders are separated by "/".
+type Template struct {
+	pattern string
+	profile *FilenameProfile
+}
+
+var templateRegex = regexp.MustCompile(`\{([a-z_]+)(?::(\d+))?\}`)
+
+var templateText = map[string]func(s *Song) string{
+	"title":        func(s *Song) string { return s.Title },
+	"artist":       func(s *Song) string { return s.Artist },
+	"album":        func(s *Song) string { return s.Album },
+	"album_artist": func(s *Song) string { return s.AlbumArtist },
+	"genre":        func(s *Song) string { return s.Genre },
+	"id": func(s *Song) string {
+		if s.Video == nil {
+			return ""
+		}
+		return s.Video.ID
+	},
+}
+
+var templateNumbers = map[string]func(s *Song) int{
+	"year":  func(s *Song) int { return s.Year },
+	"track": func(s *Song) int { return s.TrackNumber },
+}
+
+// ParseTemplate checks the pattern of a template. Its file and folder names
+// follow the rules of the given profile.
+func ParseTemplate(pattern string, profile *FilenameProfile) (Template, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return Template{}, fmt.Errorf("Template can not be empty.")
+	}
+
+	for _, match := range templateRegex.FindAllStringSubmatch(pattern, -1) {
+		name, width := match[1], match[2]
+		_, text := templateText[name]
+		_, number := templateNumbers[name]
+
+		switch {
+		case !text && !number:
+			return Template{}, fmt.Errorf("Unknown template field {%s}, expected one of: %s.", name, strings.Join(templateFieldNames(), ", "))
+		case text && width != "":
+			return Template{}, fmt.Errorf("Template field {%s} is not a number and can not be padded.", name)
+		}
+	}
+
+	rest := templateRegex.ReplaceAllString(pattern, "")
+	if strings.ContainsAny(rest, "{}") {
+		return Template{}, fmt.Errorf("Template %s has unmatched braces.", pattern)
+	}
+
+	if strings.HasPrefix(pattern, "/") || slices.Contains(strings.Split(pattern, "/"), "..") {
+		return Template{}, fmt.Errorf("Template %s must stay inside the output folder.", pattern)
+	}
+
+	return Template{pattern: pattern, profile: profile}, nil
+}
+
+func templateFieldNames() []string {
+	names := make([]string, 0, len(templateText)+len(templateNumbers))
+	for name := range templateText {
+		names = append(names, name)
+	}
+	for name := range templateNumbers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Path returns the path of the song relative to the output folder, without
+// extension. Empty fields are left out together with one of the separators
+// around them, or the brackets around them. Other characters are only
+// changed by the filename profile.
+func (t Template) Path(s *Song) string {
+	pattern, profile := t.pattern, t.profile
+	if pattern == "" {
+		pattern = defaultTemplate
+	}
+	if profile == nil {
+		profile = filenameProfiles[defaultFilenameProfile()]
+	}
+
+	// The pattern alternates literal text and fields, starting and ending
+	// with a possibly empty literal.
+	matches := templateRegex.FindAllStringSubmatchIndex(pattern, -1)
+	literals := make([]string, 0, len(matches)+1)
+	values := make([]string, 0, len(matches))
+	start := 0
+	for _, match := range matches {
+		width := ""
+		if match[4] >= 0 {
+			width = pattern[match[4]:match[5]]
+		}
+		literals = append(literals, pattern[start:match[0]])
+		values = append(values, templateValue(s, pattern[match[2]:match[3]], width, profile))
+		start = match[1]
+	}
+	literals = append(literals, pattern[start:])
+
+	for i, value := range values {
+		if value == "" {
+			literals[i], literals[i+1] = joinSeparators(literals[i], literals[i+1])
+		}
+	}
+
+	var b strings.Builder
+	for i, value := range values {
+		b.WriteString(literals[i] + value)
+	}
+	b.WriteString(literals[len(literals)-1])
+
+	// Folders left empty are dropped, a file name left empty is replaced by
+	// the video ID so the song does not take the name of its folder.
+	parts := strings.Split(b.String(), "/")
+	segments := make([]string, 0, len(parts))
+	for _, segment := range parts[:len(parts)-1] {
+		if strings.TrimSpace(segment) != "" {
+			segments = append(segments, segment)
+		}
+	}
+	name := parts[len(parts)-1]
+	if profile.Sanitize(name, 0) == "" {
+		name = templateText["id"](s)
+	}
+	segments = profile.Path(append(segments, name))
+
+	return filepath.Join(segments...)
+}
+
+// templateValue renders the field with the given name and padding width.
+func templateValue(s *Song, name string, width string, profile *FilenameProfile) string {
+	if get, ok := templateNumbers[name]; ok {
+		n := get(s)
+		if n == 0 {
+			return ""
+		}
+		w, _ := strconv.Atoi(width)
+		return fmt.Sprintf("%0*d", w, n)
+	}
+
+	// Values can not add folders of their own.
+	return profile.Sanitize(templateText[name](s), 0)
+}
+
+var closingBrackets = map[byte]byte{'(': ')', '[': ']'}
+
+// joinSeparators returns what is left of the literals before and after an
+// empty field. Brackets around the field are removed, otherwise only one of
+// the separators is kept, preferring folder separators, and none at the
+// start or end of the pattern.
+func joinSeparators(before string, after string) (string, string) {
+	if n := len(before); n > 0 && len(after) > 0 && closingBrackets[before[n-1]] == after[0] {
+		return strings.TrimRight(before[:n-1], " "), after[1:]
+	}
+
+	switch {
+	case strings.Contains(after, "/") && !strings.Contains(before, "/"):
+		return after, ""
+	case strings.Contains(before, "/"):
+		return before, ""
+	case before == "" || after == "":
+		return "", ""
+	default:
+		return before, ""
+	}
+}
+
+func (t Template) String() string {
+	if t.pattern == "" {
+		return defaultTemplate
+	}
+
+	return t.pattern
+}
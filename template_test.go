@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/kkdai/youtube/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTemplate(t *testing.T) {
+	for _, pattern := range []string{defaultTemplate, "{artist}/{album}/{track:02} - {title}", "{year}/{id}"} {
+		_, err := ParseTemplate(pattern, filenameProfiles["posix"])
+		require.NoError(t, err, pattern)
+	}
+
+	for _, pattern := range []string{"", "{name}", "{title:02}", "{artist - {title}", "../{title}", "/music/{title}"} {
+		_, err := ParseTemplate(pattern, filenameProfiles["posix"])
+		require.Error(t, err, pattern)
+	}
+}
+
+func TestTemplatePath(t *testing.T) {
+	song := &Song{
+		Title:       "Around the World",
+		Artist:      "Daft Punk",
+		Album:       "Homework",
+		TrackNumber: 7,
+		Year:        1997,
+		Video:       &youtube.Video{ID: "dwDns8x3Jb4"},
+	}
+
+	require.Equal(t, "Daft Punk - Around the World", Template{}.Path(song))
+
+	tmpl, err := ParseTemplate("{artist}/{album}/{track:02} - {title}", filenameProfiles["posix"])
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("Daft Punk", "Homework", "07 - Around the World"), tmpl.Path(song))
+
+	// Empty fields drop their separators and folders.
+	song.Album, song.TrackNumber = "", 0
+	require.Equal(t, filepath.Join("Daft Punk", "Around the World"), tmpl.Path(song))
+
+	tmpl, err = ParseTemplate("{artist} - {album} - {title} ({year})", filenameProfiles["posix"])
+	require.NoError(t, err)
+	require.Equal(t, "Daft Punk - Around the World (1997)", tmpl.Path(song))
+
+	song.Year = 0
+	require.Equal(t, "Daft Punk - Around the World", tmpl.Path(song))
+
+	tmpl, err = ParseTemplate("{artist} - {album} - {track} - {title}", filenameProfiles["posix"])
+	require.NoError(t, err)
+	require.Equal(t, "Daft Punk - Around the World", tmpl.Path(song))
+
+	tmpl, err = ParseTemplate("{artist} - {album}/{title}", filenameProfiles["posix"])
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("Daft Punk", "Around the World"), tmpl.Path(song))
+
+	tmpl, err = ParseTemplate("{artist}/{album}/{track:02} - {title}", filenameProfiles["posix"])
+	require.NoError(t, err)
+
+	// Values can not escape their folder.
+	song.Artist = "AC/DC"
+	require.Equal(t, filepath.Join("ACDC", "Around the World"), tmpl.Path(song))
+
+	tmpl, err = ParseTemplate("{genre}", filenameProfiles["posix"])
+	require.NoError(t, err)
+	require.Equal(t, "dwDns8x3Jb4", tmpl.Path(song))
+
+	// Trailing characters of values are left to the profile.
+	song.Artist, song.Title = "Wu-Tang Clan", "C.R.E.A.M."
+	tmpl, err = ParseTemplate(defaultTemplate, filenameProfiles["posix"])
+	require.NoError(t, err)
+	require.Equal(t, "Wu-Tang Clan - C.R.E.A.M.", tmpl.Path(song))
+
+	tmpl, err = ParseTemplate(defaultTemplate, filenameProfiles["windows"])
+	require.NoError(t, err)
+	require.Equal(t, "Wu-Tang Clan - C.R.E.A.M", tmpl.Path(song))
+
+	song.Title = "Intro -"
+	require.Equal(t, "Wu-Tang Clan - Intro -", tmpl.Path(song))
+
+	// A file name left empty by the profile does not turn into its folder.
+	tmpl, err = ParseTemplate("{artist}/{title}", filenameProfiles["windows"])
+	require.NoError(t, err)
+	song.Title = "???"
+	require.Equal(t, filepath.Join("Wu-Tang Clan", "dwDns8x3Jb4"), tmpl.Path(song))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"sort"
+	"time"
 
 	"github.com/charmbracelet/bubbles/timer"
 	tea "github.com/charmbracelet/bubbletea"
@@ -23,25 +24,54 @@ func filter[A any](v []A, m []bool) []A {
 	return r
 }
 
+func permute[A any](v []A, order []int) []A {
+	r := make([]A, len(order))
+	for i, j := range order {
+		r[i] = v[j]
+	}
+
+	return r
+}
+
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	if msg, ok := msg.(tea.KeyMsg); ok {
 		k := msg.String()
 		if k == "q" || k == "esc" || k == "ctrl+c" {
 			m.quitting = true
+			m.checkpoint()
 			return m, tea.Quit
 		}
 	}
 
+	var next tea.Model
+	var cmd tea.Cmd
+
 	switch m.view {
 	case int(fetch):
-		return updateFetch(msg, m)
+		next, cmd = updateFetch(msg, m)
 	case int(edit):
-		return updateEditor(msg, m)
+		next, cmd = updateEditor(msg, m)
 	case int(finish):
-		return updateFinish(msg, m)
+		next, cmd = updateFinish(msg, m)
 	default:
-		return updateEditor(msg, m) // TODO
+		next, cmd = updateEditor(msg, m) // TODO
 	}
+
+	// Save the session after every change worth resuming from.
+	switch msg := msg.(type) {
+	case fetchMsg, downloadMsg, downloadErrorMsg, downloadSkipMsg:
+		m = next.(model)
+		m.checkpoint()
+		return m, cmd
+	case tea.KeyMsg:
+		if k := msg.String(); k == "enter" || k == "ctrl+s" || next.(model).view != m.view {
+			m = next.(model)
+			m.checkpoint()
+			return m, cmd
+		}
+	}
+
+	return next, cmd
 }
 
 func updateFetch(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
@@ -52,50 +82,76 @@ func updateFetch(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 			m.fetchBar.Width = maxWidth
 		}
 		return m, nil
+	case startMsg:
+		return m, m.scheduleFetches()
 	case fetchMsg:
-		m.fetched[m.fetchCount] = true
-		m.songs = append(m.songs, *(*Song)(msg))
+		if msg.err != nil {
+			m.failedFetch += 1
+			m.fetchErrors = append(m.fetchErrors, fetchReport(m.links[msg.index], msg.err))
+		} else {
+			m.fetched[msg.index] = true
+			m.songs[msg.index] = *msg.song
+			m.songs[msg.index].Album = m.album.Title
+			m.songs[msg.index].TrackNumber = m.album.Tracks[m.links[msg.index]]
+		}
+
+		m.fetchActive -= 1
 		m.fetchCount += 1
 		m.fetchPercent = float64(m.fetchCount) / float64(len(m.links))
 
+		// Keep the worker busy with the next link in line.
+		cmd := m.scheduleFetches()
 		if m.fetchCount < len(m.links) {
-			return m, fetchCmd(m.links[m.fetchCount])
+			return m, cmd
 		}
 
 		m.links = filter(m.links, m.fetched)
 		m.songs = filter(m.songs, m.fetched)
+		m.status = make([]Status, len(m.songs))
+		m.results = make([]downloadResult, len(m.songs))
+
+		// Songs confirmed in a previous run keep their metadata and skip the editor.
+		confirmed := make([]bool, len(m.songs))
+		for i := range m.songs {
+			if entry, ok := m.archive.Entry(m.links[i]); ok {
+				entry.apply(&m.songs[i])
+				confirmed[i] = true
+			}
+		}
 
-		sort.Slice(m.songs, func(i, j int) bool {
-			return m.songs[i].Reliable < m.songs[j].Reliable
+		// Sort songs and their links together so indexes stay aligned.
+		order := make([]int, len(m.songs))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			a, b := order[i], order[j]
+			if confirmed[a] != confirmed[b] {
+				return confirmed[a]
+			}
+			return m.songs[a].Reliable < m.songs[b].Reliable
 		})
+		m.links = permute(m.links, order)
+		m.songs = permute(m.songs, order)
 
 		m.view = int(edit)
-		m.inputs[0].SetValue(m.songs[0].Title)
-		m.inputs[1].SetValue(m.songs[0].Artist)
-
-		return m, nil
-	case errorMsg:
-		m.failedFetch += 1
-		m.songs = append(m.songs, Song{})
-		m.fetchCount += 1
-		m.fetchPercent = float64(m.fetchCount) / float64(len(m.links))
-
-		if m.fetchCount < len(m.links) {
-			return m, fetchCmd(m.links[m.fetchCount])
+		if len(m.songs) == 0 {
+			return m, saveCmd(m.report())
 		}
 
-		m.links = filter(m.links, m.fetched)
-		m.songs = filter(m.songs, m.fetched)
-
-		sort.Slice(m.songs, func(i, j int) bool {
-			return m.songs[i].Reliable < m.songs[j].Reliable
-		})
+		for _, ok := range confirmed {
+			if ok {
+				m.enqueue(m.editIndx)
+				m.editIndx += 1
+			}
+		}
+		m.editPercent = float64(m.editIndx) / float64(len(m.songs))
 
-		m.view = int(edit)
-		m.inputs[0].SetValue(m.songs[0].Title)
-		m.inputs[1].SetValue(m.songs[0].Artist)
+		if m.editIndx < len(m.songs) {
+			m.loadInputs(m.editIndx)
+		}
 
-		return m, nil
+		return m, m.scheduleDownloads()
 	default:
 		return m, nil
 	}
@@ -104,6 +160,14 @@ func updateFetch(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 func updateEditor(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if msg.String() == "ctrl+f" {
+			m.toggleFailures()
+			return m, nil
+		}
+		if m.showFailures {
+			return updateFailures(msg, m)
+		}
+
 		switch msg.String() {
 		case "tab", "shift+tab", "up", "down":
 			s := msg.String()
@@ -138,56 +202,33 @@ func updateEditor(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 
 			return m, tea.Batch(cmds...)
 		case "ctrl+r":
-			m.inputs[0].SetValue(m.songs[m.editIndx].Title)
-			m.inputs[1].SetValue(m.songs[m.editIndx].Artist)
-			m.inputs[0].CursorEnd()
-			m.inputs[1].CursorEnd()
+			m.loadInputs(m.editIndx)
+			m.inputError = nil
 			return m, nil
 		case "ctrl+s":
+			m.inputError = nil
 			if m.editIndx < len(m.songs) {
 				m.skipCount += 1
+				m.status[m.editIndx] = skipped
 				m.downloadPercent = float64(m.downloadCount+m.failedCount+m.skipCount) / float64(len(m.songs))
-
-				m.editIndx += 1
-				m.editPercent = float64(m.editIndx) / float64(len(m.songs))
-			}
-
-			if m.downloadCount+m.failedCount+m.skipCount == len(m.songs) {
-				skipped := make([]string, 0, len(m.links))
-				for i := range m.links {
-					if !m.downloaded[i] {
-						skipped = append(skipped, m.links[i])
-					}
-				}
-				return m, saveCmd(skipped)
+				m.nextEdit()
 			}
 
-			if m.editIndx < len(m.songs) {
-				m.inputs[0].SetValue(m.songs[m.editIndx].Title)
-				m.inputs[1].SetValue(m.songs[m.editIndx].Artist)
-
-				m.inputs[0].CursorEnd()
-				m.inputs[1].CursorEnd()
+			if m.done() {
+				return m, saveCmd(m.report())
 			}
 
 			return m, nil
 		case "enter":
 			var cmd tea.Cmd
 			if m.editIndx < len(m.songs) {
-				m.songs[m.editIndx].Title = m.inputs[0].Value()
-				m.songs[m.editIndx].Artist = m.inputs[1].Value()
-				cmd = downloadCmd(&m.songs[m.editIndx], m.editIndx)
-
-				m.editIndx += 1
-				m.editPercent = float64(m.editIndx) / float64(len(m.songs))
-			}
-
-			if m.editIndx < len(m.songs) {
-				m.inputs[0].SetValue(m.songs[m.editIndx].Title)
-				m.inputs[1].SetValue(m.songs[m.editIndx].Artist)
+				if m.inputError = m.storeInputs(m.editIndx); m.inputError != nil {
+					return m, nil
+				}
 
-				m.inputs[0].CursorEnd()
-				m.inputs[1].CursorEnd()
+				m.enqueue(m.editIndx)
+				cmd = m.scheduleDownloads()
+				m.nextEdit()
 			}
 
 			return m, cmd
@@ -198,41 +239,66 @@ func updateEditor(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 			m.fetchBar.Width = maxWidth
 		}
 		return m, nil
+	case startMsg:
+		return m, m.scheduleDownloads()
+	case progressMsg:
+		t, ok := m.transfers[msg.index]
+		if !ok || msg.received < t.received {
+			// First chunk of a new attempt, restart the speed measurement.
+			t.started = time.Now()
+		}
+		t.received = msg.received
+		t.total = msg.total
+		m.transfers[msg.index] = t
+		return m, nil
 	case downloadMsg:
-
+		m.finishTransfer(msg.index)
+		m.activeCount -= 1
 		m.downloadCount += 1
 		m.downloadPercent = float64(m.downloadCount+m.failedCount+m.skipCount) / float64(len(m.songs))
-		m.downloaded[int(msg)] = true
+		m.status[msg.index] = downloaded
+		m.results[msg.index] = msg.result
 
-		if m.downloadCount+m.failedCount+m.skipCount == len(m.songs) {
-			skipped := make([]string, 0, len(m.links))
-			for i := range m.links {
-				if !m.downloaded[i] {
-					skipped = append(skipped, m.links[i])
-				}
-			}
-			return m, saveCmd(skipped)
+		if err := m.archive.Add(&m.songs[msg.index], msg.result.path, playlistID(source)); err != nil {
+			m.err = err
 		}
 
-		return m, nil
-	case errorMsg:
+		if m.done() {
+			return m, saveCmd(m.report())
+		}
+
+		return m, m.scheduleDownloads()
+	case downloadErrorMsg:
+		m.finishTransfer(msg.index)
+		m.activeCount -= 1
 		m.failedCount += 1
+		m.status[msg.index] = downloadFailed
+		m.results[msg.index] = msg.result
 		m.downloadPercent = float64(m.downloadCount+m.failedCount+m.skipCount) / float64(len(m.songs))
 
-		if m.downloadCount+m.failedCount+m.skipCount == len(m.songs) {
-			skipped := make([]string, 0, len(m.links))
-			for i := range m.links {
-				if !m.downloaded[i] {
-					skipped = append(skipped, m.links[i])
-				}
-			}
-			return m, saveCmd(skipped)
+		if m.done() {
+			return m, saveCmd(m.report())
 		}
 
-		return m, nil
+		return m, m.scheduleDownloads()
+	case downloadSkipMsg:
+		m.finishTransfer(msg.index)
+		m.activeCount -= 1
+		m.skipCount += 1
+		m.status[msg.index] = skipped
+		m.results[msg.index] = msg.result
+		m.downloadPercent = float64(m.downloadCount+m.failedCount+m.skipCount) / float64(len(m.songs))
+
+		if m.done() {
+			return m, saveCmd(m.report())
+		}
+
+		return m, m.scheduleDownloads()
+	case errorMsg:
+		m.err = error(msg)
+		return m, m.finish()
 	case saveMsg:
-		m.view = int(finish)
-		return m, m.timer.Init()
+		return m, m.finish()
 	}
 
 	// Handle character input and blinking
@@ -240,6 +306,96 @@ func updateEditor(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+// nextEdit moves the editor to the next song waiting for metadata. Songs
+// sent back from the finish view come first.
+func (m *model) nextEdit() {
+	if len(m.editQueue) > 0 {
+		m.editIndx, m.editQueue = m.editQueue[0], m.editQueue[1:]
+	} else {
+		m.editIndx = min(m.editIndx+1, len(m.songs))
+		for m.editIndx < len(m.songs) && m.status[m.editIndx] != pending {
+			m.editIndx += 1
+		}
+	}
+
+	edited := 0
+	for _, status := range m.status {
+		if status != pending {
+			edited += 1
+		}
+	}
+	m.editPercent = float64(edited) / float64(len(m.songs))
+
+	if m.editIndx < len(m.songs) {
+		m.loadInputs(m.editIndx)
+	}
+}
+
+// done reports whether every song was either downloaded, failed or skipped.
+func (m *model) done() bool {
+	return m.downloadCount+m.failedCount+m.skipCount == len(m.songs)
+}
+
+// report lists the outcome of every link in the run.
+func (m *model) report() []ReportEntry {
+	report := make([]ReportEntry, 0, len(m.fetchErrors)+len(m.songs))
+	report = append(report, m.fetchErrors...)
+
+	for i := range m.songs {
+		status := Skipped
+		switch m.status[i] {
+		case downloaded:
+			status = Downloaded
+		case downloadFailed:
+			status = DownloadFailed
+		}
+		report = append(report, songReport(m.links[i], &m.songs[i], status, m.results[i]))
+	}
+
+	return report
+}
+
+// scheduleFetches starts fetching queued links until every fetch worker is busy.
+func (m *model) scheduleFetches() tea.Cmd {
+	cmds := make([]tea.Cmd, 0, m.fetchWorkers)
+	for m.fetchActive < m.fetchWorkers && len(m.fetchQueue) > 0 {
+		index := m.fetchQueue[0]
+		m.fetchQueue = m.fetchQueue[1:]
+		m.fetchActive += 1
+		cmds = append(cmds, fetchCmd(m.ctx, m.links[index], index))
+	}
+
+	return tea.Batch(cmds...)
+}
+
+// enqueue queues a confirmed song for download and holds its file.
+func (m *model) enqueue(index int) {
+	m.queue = append(m.queue, index)
+	m.status[index] = queued
+	m.songs[index].Hold(output, outputFormat)
+}
+
+// scheduleDownloads starts queued downloads in FIFO order until every
+// download worker is busy.
+func (m *model) scheduleDownloads() tea.Cmd {
+	cmds := make([]tea.Cmd, 0, m.workerCount)
+	for m.activeCount < m.workerCount && len(m.queue) > 0 {
+		index := m.queue[0]
+		m.queue = m.queue[1:]
+		m.activeCount += 1
+		cmds = append(cmds, downloadCmd(m.ctx, &m.songs[index], index))
+	}
+
+	return tea.Batch(cmds...)
+}
+
+// finishTransfer marks download progress of a song as no longer active.
+func (m *model) finishTransfer(index int) {
+	t := m.transfers[index]
+	t.done = true
+	m.transfers[index] = t
+}
+
 func (m *model) updateInputs(msg tea.Msg) tea.Cmd {
 	var cmds = make([]tea.Cmd, len(m.inputs))
 
@@ -254,6 +410,56 @@ func (m *model) updateInputs(msg tea.Msg) tea.Cmd {
 
 func updateFinish(msg tea.Msg, m model) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		retry := m.retryable()
+		if len(retry) == 0 {
+			return m, nil
+		}
+
+		switch msg.String() {
+		case "up", "shift+tab":
+			if m.finishIndx > 0 {
+				m.finishIndx -= 1
+			}
+		case "down", "tab":
+			if m.finishIndx < len(retry)-1 {
+				m.finishIndx += 1
+			}
+		case " ":
+			index := retry[m.finishIndx]
+			m.selected[index] = !m.selected[index]
+		case "a":
+			// Select every song, or clear the selection if all are selected.
+			all := false
+			for _, index := range retry {
+				all = all || !m.selected[index]
+			}
+			for _, index := range retry {
+				m.selected[index] = all
+			}
+		case "enter", "e":
+			// Without a selection the song under the cursor is queued.
+			indexes := make([]int, 0, len(retry))
+			for _, index := range retry {
+				if m.selected[index] {
+					indexes = append(indexes, index)
+				}
+			}
+			if len(indexes) == 0 {
+				indexes = append(indexes, retry[m.finishIndx])
+			}
+
+			editFirst := msg.String() == "e"
+			for _, index := range indexes {
+				m.requeue(index, editFirst)
+			}
+			if editFirst && m.editIndx >= len(m.songs) {
+				m.nextEdit()
+			}
+
+			m.view = int(edit)
+			return m, m.scheduleDownloads()
+		}
 	case timer.TickMsg:
 		var cmd tea.Cmd
 		m.timer, cmd = m.timer.Update(msg)
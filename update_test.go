@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/kkdai/youtube/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchWorkers(t *testing.T) {
+	links := []string{"a", "b", "c", "d", "e"}
+	m := model{
+		links:        links,
+		fetched:      make([]bool, len(links)),
+		songs:        make([]Song, len(links)),
+		fetchQueue:   []int{0, 1, 2, 3, 4},
+		fetchWorkers: 2,
+		archive:      &Archive{entries: make(map[string]ArchiveEntry)},
+		inputs:       newInputs(),
+	}
+
+	m.scheduleFetches()
+	require.Equal(t, 2, m.fetchActive)
+	require.Equal(t, []int{2, 3, 4}, m.fetchQueue)
+
+	// Fetches finish out of order, every result lands at its own link and a
+	// new fetch only starts when one finished.
+	for _, index := range []int{1, 0, 3, 2, 4} {
+		msg := fetchMsg{index: index, song: &Song{Title: strings.ToUpper(links[index])}}
+		if links[index] == "c" {
+			msg = fetchMsg{index: index, err: errors.New("Video unavailable")}
+		}
+
+		next, _ := updateFetch(msg, m)
+		m = next.(model)
+		require.LessOrEqual(t, m.fetchActive, 2)
+		require.Equal(t, min(2, len(links)-m.fetchCount), m.fetchActive)
+	}
+
+	require.Equal(t, int(edit), m.view)
+	require.Equal(t, []string{"a", "b", "d", "e"}, m.links)
+	for i, link := range m.links {
+		require.Equal(t, strings.ToUpper(link), m.songs[i].Title)
+	}
+	require.Equal(t, "c", m.fetchErrors[0].Link)
+}
+
+func TestDownloadQueue(t *testing.T) {
+	songs := make([]Song, 5)
+	for i := range songs {
+		songs[i].Video = &youtube.Video{ID: string(rune('a' + i))}
+	}
+	m := model{
+		view:        int(edit),
+		songs:       songs,
+		links:       []string{"a", "b", "c", "d", "e"},
+		status:      make([]Status, len(songs)),
+		results:     make([]downloadResult, len(songs)),
+		transfers:   make(map[int]transfer),
+		archive:     &Archive{path: filepath.Join(t.TempDir(), "archive.json"), entries: make(map[string]ArchiveEntry)},
+		workerCount: 2,
+		editIndx:    len(songs),
+	}
+
+	// Confirmed songs hold their files, keep them out of other tests.
+	defer func(held map[string]*claim) { claims.claims = held }(claims.claims)
+	claims.claims = make(map[string]*claim)
+
+	dispatched := make([]int, 0, len(songs))
+	schedule := func(queue []int) {
+		dispatched = append(dispatched, queue[:len(queue)-len(m.queue)]...)
+		require.LessOrEqual(t, m.activeCount, m.workerCount)
+	}
+
+	for i := range songs {
+		m.enqueue(i)
+	}
+	queue := m.queue
+	m.scheduleDownloads()
+	schedule(queue)
+	require.Equal(t, []int{0, 1}, dispatched)
+
+	// Downloads finish out of order, the queue still starts songs in the
+	// order they were confirmed, one for every finished download.
+	for _, msg := range []tea.Msg{
+		downloadErrorMsg{index: 1, result: downloadResult{err: errors.New("Could not read video stream.")}},
+		downloadMsg{index: 0, result: downloadResult{path: "a.mp3"}},
+		downloadSkipMsg{index: 3, result: downloadResult{err: &ConflictError{Path: "d.mp3"}}},
+		downloadMsg{index: 2, result: downloadResult{path: "c.mp3"}},
+		downloadMsg{index: 4, result: downloadResult{path: "e.mp3"}},
+	} {
+		queue := m.queue
+		next, _ := updateEditor(msg, m)
+		m = next.(model)
+		schedule(queue)
+	}
+
+	require.Equal(t, []int{0, 1, 2, 3, 4}, dispatched)
+	require.Equal(t, 0, m.activeCount)
+	require.True(t, m.done())
+	require.Equal(t, []Status{downloaded, downloadFailed, downloaded, skipped, downloaded}, m.status)
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/muesli/reflow/indent"
+	"github.com/muesli/reflow/truncate"
+	"github.com/muesli/reflow/wordwrap"
 )
 
 // The main view, which just calls the appropriate sub-view
@@ -34,6 +38,9 @@ func fetchView(m model) string {
 	b.WriteString("\n")
 	b.WriteString(barTextStyle("Fetching video metadata.") + "\n")
 	b.WriteString(m.fetchBar.ViewAs(m.fetchPercent) + "\n\n")
+	if m.archived > 0 {
+		b.WriteString(skippedStyle.Render(fmt.Sprintf("%2d", m.archived)) + " already downloaded and skipped\n\n")
+	}
 	b.WriteString(helpStyle("Press ") + keyStyle("q") + helpStyle(" button to quit."))
 
 	return b.String()
@@ -47,12 +54,37 @@ func editorView(m model) string {
 		b.WriteString(helpStyle("Title : "))
 		b.WriteString(songStyle.Render((m.songs[m.editIndx].Video.Title)) + "\n")
 		b.WriteString(helpStyle("Author: "))
-		b.WriteString(songStyle.Render(m.songs[m.editIndx].Video.Author) + "\n\n")
+		b.WriteString(songStyle.Render(m.songs[m.editIndx].Video.Author) + "\n")
+
+		// Songs restored from a session have no formats until they are downloaded.
+		if formats := m.songs[m.editIndx].Video.Formats; len(formats) > 0 {
+			source, err := FindFormat(formats, outputFormat.Preference, outputFormat.Sources...)
+			if err != nil {
+				b.WriteString(noStyle(err.Error()) + "\n")
+			} else if warning := outputFormat.BitrateWarning(source); warning != "" {
+				b.WriteString(maybeStyle(warning) + "\n")
+			}
+		}
+		b.WriteString("\n")
 
 		for i := range m.inputs {
 			b.WriteString(m.inputs[i].View() + "\n")
 		}
 
+		// Tags keep the text above, the file name only what the filesystem allows.
+		song := m.previewSong()
+		fname, taken := song.Filename(output, outputFormat)
+		b.WriteString("\n" + helpStyle("File  : "))
+		b.WriteString(songStyle.Render(fname))
+		if taken {
+			b.WriteString(" " + maybeStyle(conflictNotes[outputFormat.Conflict]))
+		}
+		b.WriteString("\n")
+
+		if m.inputError != nil {
+			b.WriteString("\n" + noStyle(m.inputError.Error()) + "\n")
+		}
+
 		b.WriteString("\n")
 	}
 
@@ -62,6 +94,18 @@ func editorView(m model) string {
 	skipped := skippedStyle.Render(fmt.Sprintf("%2d", m.skipCount))
 	downloaded := downloadedStyle.Render(fmt.Sprintf("%2d", m.downloadCount))
 	b.WriteString(fmt.Sprintf("%s failed • %s skipped • %s downloaded • %s failed fetch\n", failed, skipped, downloaded, failedFetch))
+	var received int64
+	for _, t := range m.transfers {
+		received += t.received
+	}
+	b.WriteString(helpStyle(fmt.Sprintf("%2d queued • %2d active • %2d finished • %s received\n", len(m.queue), m.activeCount, m.downloadCount+m.failedCount, formatBytes(received))))
+
+	// Render progress of active downloads.
+	b.WriteString(transfersView(m))
+
+	if failures := failuresView(m); failures != "" {
+		b.WriteString("\n" + failures)
+	}
 
 	// Render progress bars.
 	b.WriteString("\n")
@@ -71,12 +115,45 @@ func editorView(m model) string {
 	b.WriteString(m.fetchBar.ViewAs(m.editPercent) + "\n\n")
 
 	// Render help.
-	b.WriteString(helpStyle("ctrl+r reset • ctrl+s skip • enter confirm • ↑/↓ move • q quit"))
+	if !m.showFailures {
+		b.WriteString(helpStyle("ctrl+r reset • ctrl+s skip • enter confirm • ↑/↓ move • q quit"))
+	}
 	b.WriteString("\n")
 
 	return b.String()
 }
 
+func transfersView(m model) string {
+	indexes := make([]int, 0, len(m.transfers))
+	for i, t := range m.transfers {
+		if !t.done {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+
+	var b strings.Builder
+	if len(indexes) > 0 {
+		b.WriteString("\n")
+	}
+
+	for _, i := range indexes {
+		t := m.transfers[i]
+		song := m.songs[i]
+
+		var speed int64
+		if elapsed := time.Since(t.started).Seconds(); elapsed > 0 {
+			speed = int64(float64(t.received) / elapsed)
+		}
+
+		name := truncate.StringWithTail(fmt.Sprintf("%s - %s", song.Artist, song.Title), 40, "…")
+		b.WriteString(songStyle.Width(40).Render(name))
+		b.WriteString(helpStyle(fmt.Sprintf(" %5.1f%% • %s/s\n", t.percent(), formatBytes(speed))))
+	}
+
+	return b.String()
+}
+
 func finishView(m model) string {
 	var b strings.Builder
 
@@ -86,8 +163,65 @@ func finishView(m model) string {
 	downloaded := downloadedStyle.Render(fmt.Sprintf("%2d", m.downloadCount))
 	b.WriteString(fmt.Sprintf("%s failed • %s skipped • %s downloaded\n", failed, skipped, downloaded))
 
-	b.WriteString(helpStyle(fmt.Sprintf("Exiting in %s\n", m.timer.View())))
+	if len(m.fetchErrors) > 0 {
+		b.WriteString("\n" + barTextStyle("Could not fetch.") + "\n")
+	}
+	for _, entry := range m.fetchErrors {
+		link := truncate.StringWithTail(entry.Link, 45, "…")
+		b.WriteString(failedStyle.Render(link) + helpStyle(" "+truncate.StringWithTail(entry.Error, 30, "…")) + "\n")
+	}
+
+	retry := m.retryable()
+	if len(retry) == 0 {
+		b.WriteString(helpStyle(fmt.Sprintf("Exiting in %s\n", m.timer.View())))
+		b.WriteString(fmt.Sprint(m.err))
+		return b.String()
+	}
+
+	b.WriteString("\n" + barTextStyle("Failed and skipped songs.") + "\n")
+	for i, index := range retry {
+		song := m.songs[index]
+
+		check := "[ ]"
+		if m.selected[index] {
+			check = "[x]"
+		}
+		name := truncate.StringWithTail(fmt.Sprintf("%s - %s", song.Artist, song.Title), 60, "…")
+
+		state := skippedStyle.Render(" skipped")
+		if m.status[index] == downloadFailed {
+			state = failedStyle.Render(" failed")
+		}
+
+		if i != m.finishIndx {
+			b.WriteString("  " + check + " " + name + state + "\n")
+			continue
+		}
+
+		b.WriteString(focusedStyle.Render("> "+check+" "+name) + state + "\n")
+		if err := m.results[index].err; err != nil {
+			b.WriteString(helpStyle(indent.String(wordwrap.String(err.Error(), maxWidth-6), 6)) + "\n")
+		}
+	}
+
+	b.WriteString("\n" + helpStyle("space select • a all • enter download • e edit and download • q quit") + "\n")
 	b.WriteString(fmt.Sprint(m.err))
 
 	return b.String()
 }
+
+// formatBytes renders a byte count using binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for i := n / unit; i >= unit; i /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}